@@ -7,10 +7,15 @@ import (
 	"github.com/clambin/go-common/httputils/roundtripper"
 	"github.com/clambin/tmdb/internal/degrees"
 	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbprom"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"text/tabwriter"
+	"time"
 )
 
 var (
@@ -19,6 +24,7 @@ var (
 	proxy   = flag.String("proxy", "", "Use TMDB Proxy")
 	id      = flag.Bool("id", false, "Don't look up actor names, use ID directly")
 	depth   = flag.Int("depth", 2, "Maximum number of movies between both actors (2 finds common movies")
+	stats   = flag.Bool("stats", false, "Show TMDB API call statistics when done")
 )
 
 const maxConcurrentRequests = 15
@@ -46,6 +52,12 @@ func main() {
 	if *proxy != "" {
 		tmdbClient.BaseURL = *proxy
 	}
+	registry := prometheus.NewRegistry()
+	if *stats {
+		metrics := tmdbprom.New("tmdb", "", nil)
+		registry.MustRegister(metrics)
+		tmdbClient.Instrumentation = metrics
+	}
 
 	var opts slog.HandlerOptions
 	if *debug {
@@ -76,6 +88,41 @@ func main() {
 			found = true
 		}
 	}
+
+	if *stats {
+		if err = printStats(os.Stderr, registry); err != nil {
+			l.Error("failed to collect statistics", "err", err)
+		}
+	}
+}
+
+func printStats(w io.Writer, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ENDPOINT\tCALLS\tAVG LATENCY")
+	for _, family := range families {
+		if family.GetName() != "tmdb_api_request_duration_seconds" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var endpoint string
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "endpoint" {
+					endpoint = label.GetValue()
+				}
+			}
+			h := m.GetHistogram()
+			var avg time.Duration
+			if h.GetSampleCount() > 0 {
+				avg = time.Duration(h.GetSampleSum() / float64(h.GetSampleCount()) * float64(time.Second))
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\n", endpoint, h.GetSampleCount(), avg.Round(time.Millisecond))
+		}
+	}
+	return tw.Flush()
 }
 
 func getActors(c degrees.TMDBClient) (from tmdb.Person, to tmdb.Person, err error) {
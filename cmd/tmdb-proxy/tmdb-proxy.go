@@ -1,37 +1,103 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/clambin/go-common/httputils"
 	"github.com/clambin/go-common/httputils/middleware"
 	"github.com/clambin/go-common/httputils/roundtripper"
 	"github.com/clambin/tmdb/internal/proxy"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbrecord"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
 var (
-	version        = "change-me"
-	debug          = flag.Bool("debug", false, "enable debug logging")
-	prometheusAddr = flag.String("metrics.addr", ":9090", "Prometheus metric listener address")
-	proxyAddr      = flag.String("proxy.addr", ":8888", "Proxy addr")
-	healthAddr     = flag.String("health.addr", ":8080", "Health check addr")
-	cacheExpiry    = flag.Duration("cache.ttl", 24*time.Hour, "Time to cache tmdb data")
-	redisAddr      = flag.String("cache.redis.addr", "localhost:6379", "Redis address")
-	redisDB        = flag.Int("cache.redis.db", 0, "Redis database number")
-	redisUsername  = flag.String("cache.redis.username", "", "Redis username")
-	redisPassword  = flag.String("cache.redis.password", "", "Redis password")
+	version              = "change-me"
+	debug                = flag.Bool("debug", false, "enable debug logging")
+	prometheusAddr       = flag.String("metrics.addr", ":9090", "Prometheus metric listener address")
+	proxyAddr            = flag.String("proxy.addr", ":8888", "Proxy addr")
+	healthAddr           = flag.String("health.addr", ":8080", "Health check addr")
+	adminAddr            = flag.String("admin.addr", "", "Cache administration API addr (default: disabled)")
+	adminToken           = flag.String("admin.token", "", "Token required to use the cache administration API (default: $TMDB_PROXY_ADMIN_TOKEN)")
+	tmdbToken            = flag.String("tmdb.token", "", "TMDB API read access token used for all calls to TMDB (default: $TMDB_TOKEN)")
+	tmdbTokenFile        = flag.String("tmdb.token-file", "", "File containing the TMDB API read access token")
+	apiKeys              = flag.String("auth.keys", "", "Comma-separated list of name:key API keys that clients must present")
+	apiKeysFile          = flag.String("auth.keys-file", "", "File with one name:key API key per line that clients must present. Reloaded on SIGHUP")
+	clientRate           = flag.Float64("limit.requests", 0, "Maximum requests per second per client (0: no limit)")
+	clientBurst          = flag.Int("limit.requests-burst", 100, "Maximum burst of requests per client")
+	upstreamRate         = flag.Float64("limit.upstream", 0, "Maximum requests per second per client that aren't served from cache (0: no limit)")
+	upstreamBurst        = flag.Int("limit.upstream-burst", 20, "Maximum burst of requests per client that aren't served from cache")
+	tmdbRate             = flag.Float64("limit.tmdb", 0, "Maximum requests per second to tmdb, across all clients (0: no limit)")
+	tmdbBurst            = flag.Int("limit.tmdb-burst", 20, "Maximum burst of requests to tmdb")
+	tmdbQueueTimeout     = flag.Duration("limit.tmdb-queue-timeout", 5*time.Second, "Maximum time a request waits for the tmdb rate limit before it's rejected")
+	breakerRatio         = flag.Float64("breaker.failure-ratio", 0.5, "Ratio of failed or slow tmdb calls that stops calling tmdb for -breaker.open-timeout (0: disabled)")
+	breakerSlowCall      = flag.Duration("breaker.slow-call", 5*time.Second, "Duration after which a tmdb call counts as failed (0: slow calls don't fail)")
+	breakerOpenTimeout   = flag.Duration("breaker.open-timeout", 30*time.Second, "Time to stop calling tmdb once the failure ratio is reached")
+	cacheExpiry          = flag.Duration("cache.ttl", 24*time.Hour, "Time to cache tmdb data")
+	ttlPolicy            = flag.String("cache.ttl-policy", "", "File with per-path TTLs, one \"pattern: ttl\" per line (e.g. \"/3/movie/*/credits: 72h\")")
+	honorMaxAge          = flag.Bool("cache.honor-max-age", false, "Use the max-age of tmdb's Cache-Control header as TTL")
+	changesInterval      = flag.Duration("cache.changes-interval", 0, "Interval to poll tmdb for changed movies, persons and tv shows, and purge them from the cache (0: disabled). Requires -tmdb.token")
+	negativeTTL          = flag.Duration("cache.negative-ttl", 5*time.Minute, "Time to cache tmdb's 404 responses (0: disabled)")
+	negativeStatusCodes  = flag.String("cache.negative-status-codes", "404", "Comma-separated list of tmdb error responses that are cached for -cache.negative-ttl")
+	cacheVary            = flag.String("cache.vary", "", "Comma-separated list of request headers that select a different cached response (e.g. Accept-Language)")
+	staleWhileRevalidate = flag.Duration("cache.stale-while-revalidate", time.Hour, "Time to serve expired tmdb data while refreshing it in the background")
+	staleIfError         = flag.Duration("cache.stale-if-error", 24*time.Hour, "Time to serve expired tmdb data when tmdb is unavailable")
+	warmInterval         = flag.Duration("warm.interval", 0, "Interval to prefetch popular content into the cache, starting at startup (0: disabled). The admin API can also start a warm-up. Requires -tmdb.token")
+	warmLists            = flag.String("warm.lists", "/3/trending/movie/day,/3/trending/person/day,/3/movie/popular,/3/person/popular", "Comma-separated list of tmdb lists to prefetch")
+	warmPages            = flag.Int("warm.pages", 1, "Number of pages of each list to prefetch")
+	warmPeople           = flag.Int("warm.people", 500, "Number of most popular people whose credits, and their movies' credits, are prefetched (0: disabled)")
+	warmExportURL        = flag.String("warm.export-url", "https://files.tmdb.org/p/exports", "Location of tmdb's daily id exports, used to find the most popular people")
+	warmRate             = flag.Float64("warm.rate", 5, "Maximum prefetches per second that aren't served from cache (0: no limit)")
+	recordDir            = flag.String("tmdb.record", "", "Directory to record all calls to tmdb in, for replay with -tmdb.replay")
+	replayDir            = flag.String("tmdb.replay", "", "Directory of calls recorded with -tmdb.record. The proxy serves these instead of calling tmdb, and returns 504 for calls that weren't recorded")
+	offline              = flag.Bool("offline", false, "Never call tmdb: serve cached responses and images, even if stale, and return 504 for anything that isn't cached. Changed items aren't purged")
+	cacheBackend         = flag.String("cache.backend", "redis", "Cache backend (redis, memory, disk)")
+	memoryEntries        = flag.Int("cache.memory.max-entries", 10000, "Maximum number of entries in the memory cache (0: no limit)")
+	memorySize           = flag.Int64("cache.memory.max-size", 256<<20, "Maximum size of the memory cache, in bytes (0: no limit)")
+	diskPath             = flag.String("cache.disk.path", filepath.Join(os.TempDir(), "tmdb-proxy"), "Directory of the disk cache")
+	diskSweepInterval    = flag.Duration("cache.disk.sweep-interval", time.Hour, "Interval to remove expired entries from the disk cache (0: disabled)")
+	l1TTL                = flag.Duration("cache.l1.ttl", 0, "Time to keep entries in an in-memory cache in front of the redis or disk cache (0: disabled)")
+	l1Entries            = flag.Int("cache.l1.max-entries", 1000, "Maximum number of entries in the in-memory cache (0: no limit)")
+	l1Size               = flag.Int64("cache.l1.max-size", 64<<20, "Maximum size of the in-memory cache, in bytes (0: no limit)")
+	imagePath            = flag.String("image.cache.path", "", "Directory of the image cache. If set, images are served on /t/p/{size}/{path} (empty: disabled)")
+	imageSize            = flag.Int64("image.cache.max-size", 1<<30, "Maximum size of the image cache, in bytes (0: no limit)")
+	imageBaseURL         = flag.String("image.base-url", "https://image.tmdb.org", "TMDB image server")
+	imageResize          = flag.Bool("image.resize", false, "Resize images to widths that TMDB doesn't offer")
+	redisAddr            = flag.String("cache.redis.addr", "localhost:6379", "Redis address")
+	redisDB              = flag.Int("cache.redis.db", 0, "Redis database number")
+	redisUsername        = flag.String("cache.redis.username", "", "Redis username")
+	redisPassword        = flag.String("cache.redis.password", "", "Redis password")
+	redisLock            = flag.Bool("cache.redis.lock", false, "Coalesce identical tmdb calls across replicas, using a lock in Redis")
+	redisLockTimeout     = flag.Duration("cache.redis.lock-timeout", 10*time.Second, "Maximum time to wait for another replica to call tmdb")
 )
 
+var redisClient = sync.OnceValue(func() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     *redisAddr,
+		DB:       *redisDB,
+		Username: *redisUsername,
+		Password: *redisPassword,
+	})
+})
+
 func main() {
 	flag.Parse()
 
@@ -41,10 +107,61 @@ func main() {
 	}
 	logger := slog.New(slog.NewTextHandler(os.Stderr, &opts))
 
-	logger.Info("Starting proxy",
-		"version", version,
-		slog.Group("redis", "addr", *redisAddr, "db", *redisDB),
-	)
+	backend, err := makeBackend(logger)
+	if err != nil {
+		logger.Error("failed to create cache", "err", err)
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 0 {
+		if err = runCommand(backend, flag.Args(), logger); err != nil {
+			logger.Error("command failed", "command", flag.Arg(0), "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logger.Info("Starting proxy", "version", version, "cache", *cacheBackend, "offline", *offline)
+
+	ttlRules, err := loadTTLRules(*ttlPolicy)
+	if err != nil {
+		logger.Error("failed to load ttl policy", "err", err)
+		os.Exit(1)
+	}
+
+	token, err := loadToken()
+	if err != nil {
+		logger.Error("failed to load tmdb token", "err", err)
+		os.Exit(1)
+	}
+
+	negativeCodes, err := parseStatusCodes(*negativeStatusCodes)
+	if err != nil {
+		logger.Error("invalid negative status codes", "err", err)
+		os.Exit(1)
+	}
+
+	transport, err := makeTransport()
+	if err != nil {
+		logger.Error("invalid tmdb transport", "err", err)
+		os.Exit(1)
+	}
+
+	keys, err := loadAPIKeys()
+	if err != nil {
+		logger.Error("failed to load api keys", "err", err)
+		os.Exit(1)
+	}
+	// authenticated clients don't send a tmdb key: without the proxy's token, every call to tmdb would fail
+	if keys != nil && token == "" && !*offline && *replayDir == "" {
+		logger.Error("api keys require a tmdb token")
+		os.Exit(1)
+	}
+
+	var locker proxy.Locker
+	if *redisLock {
+		locker = proxy.NewRedisLocker(redisClient())
+	}
 
 	cacheMetrics := roundtripper.NewCacheMetrics(roundtripper.CacheMetricsOptions{
 		Namespace:   "tmdb",
@@ -52,14 +169,66 @@ func main() {
 		ConstLabels: nil,
 		GetPath:     func(r *http.Request) string { return "/" },
 	})
-	prometheus.MustRegister(cacheMetrics)
+	negativeCacheMetrics := proxy.NewNegativeCacheMetrics("tmdb", "proxy", nil)
+	clientMetrics := proxy.NewClientMetrics("tmdb", "proxy", nil)
+	prometheus.MustRegister(cacheMetrics, negativeCacheMetrics, clientMetrics)
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     *redisAddr,
-		DB:       *redisDB,
-		Username: *redisUsername,
-		Password: *redisPassword,
-	})
+	upstream := proxy.NewUpstreamLimiter(rate.Limit(*tmdbRate), *tmdbBurst, *tmdbQueueTimeout, "tmdb", "proxy", nil)
+	prometheus.MustRegister(upstream)
+
+	var breaker *proxy.CircuitBreaker
+	if *breakerRatio > 0 {
+		breaker = proxy.NewCircuitBreaker(*breakerRatio, *breakerSlowCall, *breakerOpenTimeout, "tmdb", "proxy", nil)
+		prometheus.MustRegister(breaker)
+	}
+
+	var limiter *proxy.ClientLimiter
+	if *clientRate > 0 || *upstreamRate > 0 {
+		limiter = proxy.NewClientLimiter(proxy.Limits{
+			Requests:      rate.Limit(*clientRate),
+			RequestsBurst: *clientBurst,
+			Upstream:      rate.Limit(*upstreamRate),
+			UpstreamBurst: *upstreamBurst,
+		})
+	}
+
+	apiHandler := proxy.TMDBProxyHandler(backend, proxy.Options{
+		Token:                token,
+		TTL:                  *cacheExpiry,
+		TTLRules:             ttlRules,
+		HonorMaxAge:          *honorMaxAge,
+		Vary:                 splitList(*cacheVary),
+		NegativeTTL:          *negativeTTL,
+		NegativeStatusCodes:  negativeCodes,
+		DebugHeaders:         *debug,
+		StaleWhileRevalidate: *staleWhileRevalidate,
+		StaleIfError:         *staleIfError,
+		CacheMetrics:         cacheMetrics,
+		NegativeCacheMetrics: negativeCacheMetrics,
+		Locker:               locker,
+		LockTimeout:          *redisLockTimeout,
+		Upstream:             upstream,
+		Breaker:              breaker,
+		Limiter:              limiter,
+		ClientMetrics:        clientMetrics,
+		Transport:            transport,
+		Offline:              *offline,
+	}, logger.With("handler", "proxy"))
+
+	// the warmer calls TMDB with the proxy's token: without it, or offline, there's nothing to warm
+	var warmer *proxy.Warmer
+	if token != "" && !*offline {
+		warmer = &proxy.Warmer{
+			Handler:   apiHandler,
+			Lists:     splitList(*warmLists),
+			Pages:     *warmPages,
+			People:    *warmPeople,
+			ExportURL: *warmExportURL,
+			Rate:      rate.Limit(*warmRate),
+			Interval:  *warmInterval,
+			Logger:    logger.With("component", "warmer"),
+		}
+	}
 
 	requestLogger := middleware.RequestLogger(logger, slog.LevelDebug, middleware.DefaultRequestLogFormatter)
 
@@ -67,29 +236,313 @@ func main() {
 	defer cancel()
 
 	var g errgroup.Group
+	if keys != nil && *apiKeysFile != "" {
+		g.Go(func() error { reloadAPIKeys(ctx, keys, logger); return nil })
+	}
+	if tiered, ok := backend.(*proxy.TieredCache); ok {
+		g.Go(func() error { return tiered.Run(ctx) })
+	}
+	if disk := diskCache(backend); disk != nil && *diskSweepInterval > 0 {
+		g.Go(func() error { return disk.Run(ctx, *diskSweepInterval, logger.With("component", "cache")) })
+	}
+	if *changesInterval > 0 && *offline {
+		logger.Warn("offline: not purging changed items")
+	} else if *changesInterval > 0 {
+		adminBackend, ok := backend.(proxy.AdminBackend)
+		if !ok || token == "" {
+			logger.Error("purging changed items requires a tmdb token and a cache backend that supports it")
+			os.Exit(1)
+		}
+		poller := proxy.ChangesPoller{
+			// the poller shares the proxy's tmdb rate limit and circuit breaker
+			Client: tmdb.New(token, &http.Client{
+				Transport: proxy.UpstreamTransport{Upstream: upstream, Breaker: breaker, Next: transport},
+				Timeout:   30 * time.Second,
+			}),
+			Backend:  adminBackend,
+			Interval: *changesInterval,
+			Logger:   logger.With("component", "changes"),
+		}
+		g.Go(func() error { return poller.Run(ctx) })
+	}
+	if *warmInterval > 0 && *offline {
+		logger.Warn("offline: not warming the cache")
+	} else if *warmInterval > 0 {
+		if warmer == nil {
+			logger.Error("warming the cache requires a tmdb token")
+			os.Exit(1)
+		}
+		g.Go(func() error { return warmer.Run(ctx) })
+	}
 	g.Go(func() error {
 		return httputils.RunServer(ctx, &http.Server{
 			Addr:    *prometheusAddr,
 			Handler: promhttp.Handler(),
 		})
 	})
+	if *adminAddr != "" {
+		adminBackend, ok := backend.(proxy.AdminBackend)
+		token := cmp.Or(*adminToken, os.Getenv("TMDB_PROXY_ADMIN_TOKEN"))
+		if !ok || token == "" {
+			logger.Error("admin API requires a token and a cache backend that supports it")
+			os.Exit(1)
+		}
+		g.Go(func() error {
+			return httputils.RunServer(ctx, &http.Server{
+				Addr:    *adminAddr,
+				Handler: requestLogger(proxy.AdminHandler(ctx, adminBackend, splitList(*cacheVary), warmer, token, logger.With("handler", "admin"))),
+			})
+		})
+	}
 	g.Go(func() error {
 		return httputils.RunServer(ctx, &http.Server{
 			Addr:    *healthAddr,
-			Handler: proxy.HealthHandler(redisClient, logger.With("handler", "health")),
+			Handler: proxy.HealthHandler(backend, upstream, breaker, logger.With("handler", "health")),
 		})
 	})
+	// images are served with the same authentication and limits as the API
+	proxyHandler, err := makeProxyHandler(apiHandler, proxy.ImageOptions{
+		BaseURL:       *imageBaseURL,
+		Resize:        *imageResize,
+		Upstream:      upstream,
+		Limiter:       limiter,
+		ClientMetrics: clientMetrics,
+		Offline:       *offline,
+	}, authenticate(keys, logger), logger)
+	if err != nil {
+		logger.Error("failed to create image cache", "err", err)
+		os.Exit(1)
+	}
 	g.Go(func() error {
 		return httputils.RunServer(ctx, &http.Server{
-			Addr: *proxyAddr,
-			Handler: requestLogger(
-				proxy.TMDBProxyHandler("", redisClient, *cacheExpiry, cacheMetrics, logger.With("handler", "proxy")),
-			),
+			Addr:    *proxyAddr,
+			Handler: requestLogger(proxyHandler),
 		})
 	})
 
-	if err := g.Wait(); err != nil {
+	if err = g.Wait(); err != nil {
 		slog.Error("failed to start TMDB proxy server", "err", err)
 		os.Exit(1)
 	}
 }
+
+// makeTransport returns the transport to call tmdb: a recorder, a replayer, or nil for the default transport.
+func makeTransport() (http.RoundTripper, error) {
+	switch {
+	case *recordDir != "" && *replayDir != "":
+		return nil, errors.New("-tmdb.record and -tmdb.replay are mutually exclusive")
+	case *recordDir != "":
+		return &tmdbrecord.Recorder{Directory: *recordDir}, nil
+	case *replayDir != "":
+		if _, err := os.Stat(*replayDir); err != nil {
+			return nil, err
+		}
+		return tmdbrecord.Replayer{Directory: *replayDir}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// runCommand runs the export or import command:
+//
+//	tmdb-proxy [flags] export <file>
+//	tmdb-proxy [flags] import [-ttl <duration>] [-keep <duration>] <file>
+//
+// A file "-" is stdout or stdin.
+func runCommand(backend proxy.Backend, args []string, logger *slog.Logger) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cmd := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	ttl := cmd.Duration("ttl", 0, "Time the imported responses are fresh (0: as long as they were when exported)")
+	keep := cmd.Duration("keep", 0, "Time the imported responses are kept once stale, e.g. for -offline (0: forever)")
+	if err := cmd.Parse(args[1:]); err != nil {
+		return err
+	}
+	if cmd.NArg() != 1 {
+		return fmt.Errorf("usage: %s <file>", args[0])
+	}
+	file := cmd.Arg(0)
+
+	switch args[0] {
+	case "export":
+		adminBackend, ok := backend.(proxy.AdminBackend)
+		if !ok {
+			return fmt.Errorf("cache backend %s doesn't support export", *cacheBackend)
+		}
+		if file == "-" {
+			count, err := proxy.ExportSnapshot(ctx, adminBackend, os.Stdout)
+			logger.Info("cache exported", "responses", count)
+			return err
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		count, err := proxy.ExportSnapshot(ctx, adminBackend, f)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		logger.Info("cache exported", "responses", count, "file", file)
+		return err
+	case "import":
+		r := os.Stdin
+		if file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			r = f
+		}
+		count, err := proxy.ImportSnapshot(ctx, backend, r, *ttl, *keep)
+		logger.Info("cache imported", "responses", count)
+		return err
+	default:
+		return fmt.Errorf("unknown command %q. valid commands: export, import", args[0])
+	}
+}
+
+func makeBackend(logger *slog.Logger) (proxy.Backend, error) {
+	var backend proxy.Backend
+	var invalidator proxy.Invalidator
+	switch *cacheBackend {
+	case "redis":
+		backend = proxy.NewRedisCache(redisClient())
+		invalidator = proxy.NewRedisInvalidator(redisClient(), "github.com/clambin/tmdb|invalidate")
+	case "memory":
+		return proxy.NewMemoryCache(*memoryEntries, *memorySize), nil
+	case "disk":
+		var err error
+		if backend, err = proxy.NewDiskCache(*diskPath); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid cache backend: %q", *cacheBackend)
+	}
+
+	if *l1TTL > 0 {
+		backend = &proxy.TieredCache{
+			L1:          proxy.NewMemoryCache(*l1Entries, *l1Size),
+			L1TTL:       *l1TTL,
+			L2:          backend,
+			Invalidator: invalidator,
+			Logger:      logger.With("component", "cache"),
+		}
+	}
+	return backend, nil
+}
+
+// diskCache returns the DiskCache used by backend, or nil if it doesn't use one.
+func diskCache(backend proxy.Backend) *proxy.DiskCache {
+	if tiered, ok := backend.(*proxy.TieredCache); ok {
+		backend = tiered.L2
+	}
+	disk, _ := backend.(*proxy.DiskCache)
+	return disk
+}
+
+func loadTTLRules(filename string) ([]proxy.TTLRule, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return proxy.ParseTTLRules(f)
+}
+
+func loadToken() (string, error) {
+	if *tmdbTokenFile != "" {
+		token, err := os.ReadFile(*tmdbTokenFile)
+		return strings.TrimSpace(string(token)), err
+	}
+	return cmp.Or(*tmdbToken, os.Getenv("TMDB_TOKEN")), nil
+}
+
+func loadAPIKeys() (*proxy.APIKeys, error) {
+	switch {
+	case *apiKeysFile != "":
+		f, err := os.Open(*apiKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = f.Close() }()
+		return proxy.ParseAPIKeys(f)
+	case *apiKeys != "":
+		return proxy.ParseAPIKeys(strings.NewReader(strings.ReplaceAll(*apiKeys, ",", "\n")))
+	default:
+		return nil, nil
+	}
+}
+
+// reloadAPIKeys reloads the API keys file whenever the proxy receives SIGHUP, until the context is canceled.
+func reloadAPIKeys(ctx context.Context, keys *proxy.APIKeys, logger *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		f, err := os.Open(*apiKeysFile)
+		if err == nil {
+			err = keys.Load(f)
+			_ = f.Close()
+		}
+		if err != nil {
+			logger.Error("failed to reload api keys", "err", err)
+			continue
+		}
+		logger.Info("api keys reloaded", "keys", keys.Len())
+	}
+}
+
+func authenticate(keys *proxy.APIKeys, logger *slog.Logger) func(http.Handler) http.Handler {
+	if keys == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return proxy.Authenticate(keys, logger.With("handler", "auth"))
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parseStatusCodes(s string) ([]int, error) {
+	var codes []int
+	for _, item := range splitList(s) {
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 400 || code > 599 {
+			return nil, fmt.Errorf("invalid status code %q", item)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// makeProxyHandler adds the image route to the TMDB API proxy, if the image cache is enabled. Both are authenticated
+// by auth.
+func makeProxyHandler(apiHandler http.Handler, imageOptions proxy.ImageOptions, auth func(http.Handler) http.Handler, logger *slog.Logger) (http.Handler, error) {
+	if *imagePath == "" {
+		return auth(apiHandler), nil
+	}
+	images, err := proxy.NewImageCache(*imagePath, *imageSize)
+	if err != nil {
+		return nil, err
+	}
+	m := http.NewServeMux()
+	m.Handle("/t/p/", proxy.ImageHandler(images, imageOptions, logger.With("handler", "image")))
+	m.Handle("/", apiHandler)
+	return auth(m), nil
+}
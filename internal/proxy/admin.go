@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminHandler serves the cache administration API. All requests must present token as a bearer token.
+//
+//	GET    /cache/entry?url=<url>       returns the cached response for the URL (e.g. "/3/movie/550?language=en-US")
+//	DELETE /cache/entry?url=<url>       removes the cached response for the URL
+//	DELETE /cache/entries?prefix=<url>  removes all cached responses whose URL starts with prefix (e.g. "/3/person/287/")
+//	DELETE /cache/entries               removes all cached responses
+//	GET    /cache/stats                 returns the number of cached responses and the size of the cache
+//	POST   /cache/warm                  starts warming the cache
+//	GET    /cache/warm                  returns the progress of the running warm-up, or the result of the last one
+//
+// vary must match the proxy's Options.Vary. GET /cache/entry then returns the variant selected by the request's
+// headers, and DELETE /cache/entry removes all variants. If warmer is nil (e.g. the proxy is offline), the /cache/warm
+// endpoints return 503 Service Unavailable. Warm-ups started through the API run until they finish or ctx is canceled.
+func AdminHandler(ctx context.Context, backend AdminBackend, vary []string, warmer *Warmer, token string, logger *slog.Logger) http.Handler {
+	a := admin{
+		ctx:       ctx,
+		backend:   backend,
+		responses: responseCache{Namespace: cacheNamespace, Backend: backend, Vary: vary},
+		warmer:    warmer,
+		logger:    logger,
+	}
+	m := http.NewServeMux()
+	m.HandleFunc("GET /cache/entry", a.getEntry)
+	m.HandleFunc("DELETE /cache/entry", a.deleteEntry)
+	m.HandleFunc("DELETE /cache/entries", a.deleteEntries)
+	m.HandleFunc("GET /cache/stats", a.stats)
+	if warmer != nil {
+		m.HandleFunc("POST /cache/warm", a.startWarm)
+		m.HandleFunc("GET /cache/warm", a.warmStats)
+	} else {
+		m.HandleFunc("/cache/warm", func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "cache warming is not available", http.StatusServiceUnavailable)
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tmdb-proxy admin"`)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		m.ServeHTTP(w, r)
+	})
+}
+
+type admin struct {
+	ctx       context.Context
+	backend   AdminBackend
+	responses responseCache
+	warmer    *Warmer
+	logger    *slog.Logger
+}
+
+type adminEntry struct {
+	Key        string    `json:"key"`
+	StoredAt   time.Time `json:"stored_at"`
+	FreshUntil time.Time `json:"fresh_until"`
+	Age        float64   `json:"age_seconds"`
+	TTL        float64   `json:"ttl_seconds"`
+	Fresh      bool      `json:"fresh"`
+	StatusCode int       `json:"status_code"`
+	Size       int       `json:"size"`
+}
+
+func (a admin) getEntry(w http.ResponseWriter, r *http.Request) {
+	req, ok := cachedRequest(w, r)
+	if !ok {
+		return
+	}
+	for _, header := range a.responses.Vary {
+		req.Header[http.CanonicalHeaderKey(header)] = r.Header.Values(header)
+	}
+	entry, err := a.responses.Get(r.Context(), req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeAdminJSON(w, adminEntry{
+		Key:        a.responses.getKey(req),
+		StoredAt:   entry.storedAt,
+		FreshUntil: entry.freshUntil,
+		Age:        entry.age().Seconds(),
+		TTL:        max(0, time.Until(entry.freshUntil).Seconds()),
+		Fresh:      entry.fresh(),
+		StatusCode: entry.statusCode,
+		Size:       len(entry.body),
+	})
+}
+
+func (a admin) deleteEntry(w http.ResponseWriter, r *http.Request) {
+	req, ok := cachedRequest(w, r)
+	if !ok {
+		return
+	}
+	// if the proxy varies responses by request headers, the variants' keys have a suffix "|<header>=<value>"
+	key := (&responseCache{Namespace: cacheNamespace}).getKey(req)
+	var deleted int
+	if len(a.responses.Vary) == 0 {
+		if err := a.backend.Delete(r.Context(), key); err != nil {
+			a.writeError(w, err)
+			return
+		}
+		deleted++
+	}
+	err := a.backend.Scan(r.Context(), key+"|", func(key string) error {
+		deleted++
+		return a.backend.Delete(r.Context(), key)
+	})
+	a.logger.Info("cache entry deleted", "key", key, "deleted", deleted, "err", err)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeAdminJSON(w, struct {
+		Deleted int `json:"deleted"`
+	}{Deleted: deleted})
+}
+
+func (a admin) deleteEntries(w http.ResponseWriter, r *http.Request) {
+	// accept "/3/person/287/*", as well as "/3/person/287/"
+	prefix := strings.TrimSuffix(r.FormValue("prefix"), "*")
+	keyPrefix := cacheNamespace + "|"
+	if prefix != "" {
+		keyPrefix += http.MethodGet + "|" + prefix
+	}
+	var deleted int
+	err := a.backend.Scan(r.Context(), keyPrefix, func(key string) error {
+		if err := a.backend.Delete(r.Context(), key); err != nil {
+			return err
+		}
+		deleted++
+		return nil
+	})
+	a.logger.Info("cache entries deleted", "prefix", keyPrefix, "deleted", deleted, "err", err)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeAdminJSON(w, struct {
+		Deleted int `json:"deleted"`
+	}{Deleted: deleted})
+}
+
+func (a admin) stats(w http.ResponseWriter, r *http.Request) {
+	var stats struct {
+		Backend BackendStats `json:"backend"`
+		Entries int          `json:"entries"`
+	}
+	var err error
+	if stats.Backend, err = a.backend.Stats(r.Context()); err == nil {
+		err = a.backend.Scan(r.Context(), cacheNamespace+"|", func(string) error {
+			stats.Entries++
+			return nil
+		})
+	}
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeAdminJSON(w, stats)
+}
+
+func (a admin) startWarm(w http.ResponseWriter, _ *http.Request) {
+	statusCode := http.StatusConflict
+	// the warm-up outlives the request, but not the server
+	if a.warmer.Start(a.ctx) {
+		a.logger.Info("cache warm-up started")
+		statusCode = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(a.warmer.Stats())
+}
+
+func (a admin) warmStats(w http.ResponseWriter, _ *http.Request) {
+	writeAdminJSON(w, a.warmer.Stats())
+}
+
+// cachedRequest returns the request whose response is cached under the url parameter.
+func cachedRequest(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	target := r.FormValue("url")
+	if !strings.HasPrefix(target, "/") {
+		http.Error(w, "url must be a path, e.g. /3/movie/550", http.StatusBadRequest)
+		return nil, false
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		http.Error(w, "invalid url: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return req, true
+}
+
+func (a admin) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, errors.ErrUnsupported):
+		http.Error(w, "not supported by the cache backend", http.StatusNotImplemented)
+	default:
+		a.logger.Warn("admin request failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(value)
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+
+	backend := NewMemoryCache(0, 0)
+	h := TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Hour}, discardLogger)
+	for _, target := range []string{"/3/person/287", "/3/person/287/images", "/3/person/287/movie_credits?language=en-US", "/3/person/2870", "/3/movie/550"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	// entries of other applications sharing the cache are never touched
+	require.NoError(t, backend.Set(t.Context(), "other|GET|/3/movie/550", []byte("foo"), time.Hour))
+
+	admin := AdminHandler(t.Context(), backend, nil, nil, "secret", discardLogger)
+	do := func(method, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodGet, "/cache/stats")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"entries":5`)
+	assert.Contains(t, w.Body.String(), `"keys":6`)
+
+	w = do(http.MethodGet, "/cache/entry?url="+url.QueryEscape("/3/person/287/movie_credits?language=en-US"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"key":"github.com/clambin/tmdb|GET|/3/person/287/movie_credits?include_adult=false&language=en-US"`)
+	assert.Contains(t, w.Body.String(), `"fresh":true`)
+	assert.Contains(t, w.Body.String(), `"status_code":200`)
+
+	w = do(http.MethodGet, "/cache/entry?url=/3/movie/551")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = do(http.MethodGet, "/cache/entry?url=movie")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(http.MethodDelete, "/cache/entry?url=/3/movie/550")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = do(http.MethodGet, "/cache/entry?url=/3/movie/550")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(http.MethodDelete, "/cache/entries?prefix="+url.QueryEscape("/3/person/287/*"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"deleted":2}`, w.Body.String())
+	w = do(http.MethodGet, "/cache/entry?url=/3/person/287")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(http.MethodDelete, "/cache/entries")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"deleted":2}`, w.Body.String())
+	count, _ := backend.Len()
+	assert.Equal(t, 1, count)
+
+	// requests must be authenticated
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/stats", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAdminHandler_Vary(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+
+	backend := NewMemoryCache(0, 0)
+	vary := []string{"Accept-Language"}
+	h := TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Hour, Vary: vary}, discardLogger)
+	for _, language := range []string{"", "nl"} {
+		r := httptest.NewRequest(http.MethodGet, "/3/movie/550", nil)
+		if language != "" {
+			r.Header.Set("Accept-Language", language)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	admin := AdminHandler(t.Context(), backend, vary, nil, "secret", discardLogger)
+	do := func(method, target, language string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		if language != "" {
+			r.Header.Set("Accept-Language", language)
+		}
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		return w
+	}
+
+	// the request's headers select the variant
+	w := do(http.MethodGet, "/cache/entry?url=/3/movie/550", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"key":"github.com/clambin/tmdb|GET|/3/movie/550?include_adult=false&language=en-US|accept-language="`)
+	w = do(http.MethodGet, "/cache/entry?url=/3/movie/550", "nl")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `|accept-language=nl"`)
+	w = do(http.MethodGet, "/cache/entry?url=/3/movie/550", "fr")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// all variants are deleted
+	w = do(http.MethodDelete, "/cache/entry?url=/3/movie/550", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"deleted":2}`, w.Body.String())
+	count, _ := backend.Len()
+	assert.Zero(t, count)
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// APIKeys holds the API keys that clients use to authenticate with the proxy. Each key belongs to a named client.
+// APIKeys is safe for concurrent use: keys can be reloaded while the proxy is serving requests.
+type APIKeys struct {
+	lock sync.RWMutex
+	keys map[[sha256.Size]byte]string
+}
+
+// ParseAPIKeys reads API keys, one per line, in the format "name:key". Empty lines and lines starting with '#'
+// are ignored.
+func ParseAPIKeys(r io.Reader) (*APIKeys, error) {
+	var k APIKeys
+	return &k, k.Load(r)
+}
+
+// Load replaces the API keys with the ones read from r, in the format of ParseAPIKeys. If r contains an invalid
+// entry, the current keys are kept.
+func (k *APIKeys) Load(r io.Reader) error {
+	keys := make(map[[sha256.Size]byte]string)
+	scanner := bufio.NewScanner(r)
+	var lineNo int
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, key, ok := strings.Cut(line, ":")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" {
+			return fmt.Errorf("line %d: expected name:key", lineNo)
+		}
+		if _, exists := keys[sha256.Sum256([]byte(key))]; exists {
+			return fmt.Errorf("line %d: duplicate key for %q", lineNo, name)
+		}
+		keys[sha256.Sum256([]byte(key))] = name
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.keys = keys
+	return nil
+}
+
+// Len returns the number of API keys.
+func (k *APIKeys) Len() int {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return len(k.keys)
+}
+
+// client returns the name of the client that owns the key.
+func (k *APIKeys) client(key string) (string, bool) {
+	// keys are looked up by their hash, so lookup time doesn't depend on how much of a key is correct
+	hash := sha256.Sum256([]byte(key))
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	name, ok := k.keys[hash]
+	return name, ok
+}
+
+var errMissingAPIKey = errors.New("missing api key")
+
+// Authenticate returns a middleware that only lets through requests with a valid API key, passed as a bearer token
+// (e.g. by a tmdb.Client configured with the proxy's API key). The key is removed from the request, so it's never
+// forwarded to TMDB. The name of the client is added to the request's context and can be retrieved with ClientName.
+func Authenticate(keys *APIKeys, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			var err error
+			var name string
+			if !ok || key == "" {
+				err = errMissingAPIKey
+			} else if name, ok = keys.client(key); !ok {
+				err = errors.New("invalid api key")
+			}
+			if err != nil {
+				logger.Debug("request rejected", "err", err, "remote", r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="tmdb-proxy"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), clientNameKey{}, name))
+			r.Header = r.Header.Clone()
+			r.Header.Del("Authorization")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type clientNameKey struct{}
+
+// ClientName returns the name of the client that made the request, as authenticated by Authenticate.
+func ClientName(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(clientNameKey{}).(string)
+	return name, ok
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys(strings.NewReader(`# internal tools
+degrees: key-1
+dashboard:key-2
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 2, keys.Len())
+	name, ok := keys.client("key-1")
+	assert.True(t, ok)
+	assert.Equal(t, "degrees", name)
+	_, ok = keys.client("key-3")
+	assert.False(t, ok)
+
+	// invalid input doesn't change the keys
+	assert.Error(t, keys.Load(strings.NewReader("degrees")))
+	assert.Error(t, keys.Load(strings.NewReader("degrees:")))
+	assert.Error(t, keys.Load(strings.NewReader("a:key-1\nb:key-1")))
+	assert.Equal(t, 2, keys.Len())
+
+	require.NoError(t, keys.Load(strings.NewReader("degrees: key-3")))
+	assert.Equal(t, 1, keys.Len())
+	_, ok = keys.client("key-1")
+	assert.False(t, ok)
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys, err := ParseAPIKeys(strings.NewReader("degrees:key-1"))
+	require.NoError(t, err)
+
+	h := Authenticate(keys, discardLogger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, _ := ClientName(r.Context())
+		_, _ = w.Write([]byte(name + "|" + r.Header.Get("Authorization")))
+	}))
+
+	tests := []struct {
+		name           string
+		authorization  string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{name: "valid key", authorization: "Bearer key-1", wantStatusCode: http.StatusOK, wantBody: "degrees|"},
+		{name: "invalid key", authorization: "Bearer key-2", wantStatusCode: http.StatusUnauthorized, wantBody: "invalid api key\n"},
+		{name: "missing key", wantStatusCode: http.StatusUnauthorized, wantBody: "missing api key\n"},
+		{name: "not a bearer token", authorization: "Basic key-1", wantStatusCode: http.StatusUnauthorized, wantBody: "missing api key\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/3/movie/1", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantStatusCode, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestTMDBProxyHandler_Token(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tmdb-token" || r.URL.Query().Has("api_key") {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(r.URL.RawQuery))
+	}))
+	t.Cleanup(s.Close)
+
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Minute, Token: "tmdb-token"}, discardLogger)
+
+	r := httptest.NewRequest(http.MethodGet, "/3/movie/1?api_key=client-key&language=en-US", nil)
+	r.Header.Set("Authorization", "Bearer client-token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "language=en-US", w.Body.String())
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbrecord"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var _ prometheus.Collector = &CircuitBreaker{}
+
+// CircuitBreaker stops calling TMDB when too many calls fail, so requests fail fast (or are served from stale cached
+// responses) instead of waiting for TMDB to time out.
+//
+// The breaker opens when, within Window, at least MinCalls calls were made and at least FailureRatio of them failed.
+// A call fails if TMDB can't be reached, returns a 5xx response or takes longer than SlowCall. After OpenTimeout, the
+// breaker lets Probes calls through (half-open): if all succeed, the breaker closes. If one fails, it opens again.
+type CircuitBreaker struct {
+	FailureRatio float64
+	// SlowCall is the duration after which a call counts as failed (0: slow calls don't fail).
+	SlowCall    time.Duration
+	OpenTimeout time.Duration
+	// MinCalls defaults to 10.
+	MinCalls int
+	// Window defaults to 30s.
+	Window time.Duration
+	// Probes defaults to 3.
+	Probes int
+
+	lock        sync.Mutex
+	current     circuitState
+	generation  uint64
+	windowStart time.Time
+	calls       int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+	stateGauge  prometheus.GaugeFunc
+	opened      prometheus.Counter
+	rejected    prometheus.Counter
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitHalfOpen:
+		return "half-open"
+	case circuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// NewCircuitBreaker returns a CircuitBreaker that opens when failureRatio of the calls fail, or take longer than
+// slowCall, and stays open for openTimeout. Its metrics use the provided namespace, subsystem and constLabels.
+func NewCircuitBreaker(failureRatio float64, slowCall time.Duration, openTimeout time.Duration, namespace, subsystem string, constLabels prometheus.Labels) *CircuitBreaker {
+	b := CircuitBreaker{
+		FailureRatio: failureRatio,
+		SlowCall:     slowCall,
+		OpenTimeout:  openTimeout,
+		opened: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "upstream_circuit_opened_total",
+			Help:        "Number of times the circuit breaker opened",
+			ConstLabels: constLabels,
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "upstream_circuit_rejected_total",
+			Help:        "Number of requests rejected because the circuit breaker was open",
+			ConstLabels: constLabels,
+		}),
+	}
+	b.stateGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   subsystem,
+		Name:        "upstream_circuit_state",
+		Help:        "State of the circuit breaker (0: closed, 1: half-open, 2: open)",
+		ConstLabels: constLabels,
+	}, func() float64 {
+		state, _ := b.state()
+		return float64(state)
+	})
+	return &b
+}
+
+// CircuitOpenError is returned when a call to TMDB was not made, because the circuit breaker is open.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("tmdb circuit breaker open: retry after %s", e.RetryAfter)
+}
+
+// allow reports whether a call to TMDB may be made. If so, the caller must report the outcome of the call by calling
+// done, even if the call was not made after all. Otherwise, allow returns a CircuitOpenError.
+func (b *CircuitBreaker) allow() (done func(statusCode int, duration time.Duration, err error), err error) {
+	if b == nil {
+		return func(int, time.Duration, error) {}, nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	if b.current == circuitOpen {
+		if remaining := b.openedAt.Add(b.openTimeout()).Sub(now); remaining > 0 {
+			b.rejected.Inc()
+			return nil, &CircuitOpenError{RetryAfter: remaining}
+		}
+		b.setState(circuitHalfOpen, now)
+	}
+	if b.current == circuitHalfOpen {
+		if b.probes >= cmp.Or(b.Probes, 3) {
+			b.rejected.Inc()
+			return nil, &CircuitOpenError{RetryAfter: time.Second}
+		}
+		b.probes++
+	}
+	generation := b.generation
+	return func(statusCode int, duration time.Duration, err error) {
+		b.record(generation, statusCode, duration, err)
+	}, nil
+}
+
+// record registers the outcome of a call. Calls that were allowed before the breaker changed state are ignored.
+//
+// A call fails if TMDB couldn't be reached, returned a 5xx response or was too slow. Calls that were throttled,
+// canceled by the client or not recorded (when replaying recorded calls) say nothing about TMDB's health, and don't
+// count.
+func (b *CircuitBreaker) record(generation uint64, statusCode int, duration time.Duration, err error) {
+	var throttled *ThrottledError
+	ignored := errors.As(err, &throttled) || errors.Is(err, context.Canceled) || errors.Is(err, tmdbrecord.ErrNotRecorded) ||
+		statusCode == http.StatusTooManyRequests
+	failed := err != nil || statusCode >= http.StatusInternalServerError || (b.SlowCall > 0 && duration > b.SlowCall)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if generation != b.generation {
+		return
+	}
+	now := time.Now()
+	switch {
+	case b.current == circuitHalfOpen && ignored:
+		// free the probe for another call
+		b.probes--
+	case ignored:
+	case b.current == circuitClosed:
+		if now.Sub(b.windowStart) > cmp.Or(b.Window, 30*time.Second) {
+			b.windowStart, b.calls, b.failures = now, 0, 0
+		}
+		b.calls++
+		if failed {
+			b.failures++
+		}
+		if b.calls >= cmp.Or(b.MinCalls, 10) && float64(b.failures) >= b.failureRatio()*float64(b.calls) {
+			b.setState(circuitOpen, now)
+		}
+	case b.current == circuitHalfOpen:
+		if failed {
+			b.setState(circuitOpen, now)
+		} else if b.successes++; b.successes >= cmp.Or(b.Probes, 3) {
+			b.setState(circuitClosed, now)
+		}
+	}
+}
+
+// setState changes the state of the breaker. The lock must be held.
+func (b *CircuitBreaker) setState(state circuitState, now time.Time) {
+	b.current = state
+	b.generation++
+	b.windowStart, b.calls, b.failures = now, 0, 0
+	b.probes, b.successes = 0, 0
+	if state == circuitOpen {
+		b.openedAt = now
+		b.opened.Inc()
+	}
+}
+
+// State returns the state of the breaker ("closed", "half-open" or "open") and, if open, how long it stays open.
+func (b *CircuitBreaker) State() (string, time.Duration) {
+	state, remaining := b.state()
+	return state.String(), remaining
+}
+
+func (b *CircuitBreaker) state() (circuitState, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.current != circuitOpen {
+		return b.current, 0
+	}
+	return b.current, max(0, time.Until(b.openedAt.Add(b.openTimeout())))
+}
+
+func (b *CircuitBreaker) openTimeout() time.Duration {
+	return cmp.Or(b.OpenTimeout, 30*time.Second)
+}
+
+func (b *CircuitBreaker) failureRatio() float64 {
+	return cmp.Or(b.FailureRatio, 0.5)
+}
+
+func (b *CircuitBreaker) Describe(ch chan<- *prometheus.Desc) {
+	b.stateGauge.Describe(ch)
+	b.opened.Describe(ch)
+	b.rejected.Describe(ch)
+}
+
+func (b *CircuitBreaker) Collect(ch chan<- prometheus.Metric) {
+	b.stateGauge.Collect(ch)
+	b.opened.Collect(ch)
+	b.rejected.Collect(ch)
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(0.5, 100*time.Millisecond, 50*time.Millisecond, "tmdb", "proxy", nil)
+	b.MinCalls = 4
+	b.Probes = 2
+
+	call := func(statusCode int, duration time.Duration, err error) error {
+		done, allowErr := b.allow()
+		if allowErr == nil {
+			done(statusCode, duration, err)
+		}
+		return allowErr
+	}
+
+	// failures below the ratio, throttled and canceled calls don't open the breaker
+	require.NoError(t, call(http.StatusOK, time.Millisecond, nil))
+	require.NoError(t, call(http.StatusOK, time.Millisecond, nil))
+	require.NoError(t, call(http.StatusOK, time.Millisecond, nil))
+	require.NoError(t, call(http.StatusBadGateway, time.Millisecond, nil))
+	require.NoError(t, call(0, 0, &ThrottledError{RetryAfter: time.Second}))
+	require.NoError(t, call(0, 0, context.Canceled))
+	state, _ := b.State()
+	assert.Equal(t, "closed", state)
+
+	// errors and slow calls do
+	require.NoError(t, call(0, time.Millisecond, errors.New("connection refused")))
+	require.NoError(t, call(http.StatusOK, time.Second, nil))
+	state, openFor := b.State()
+	assert.Equal(t, "open", state)
+	assert.NotZero(t, openFor)
+	assert.Equal(t, 2.0, testutil.ToFloat64(b.stateGauge))
+	assert.Equal(t, 1, testutil.CollectAndCount(b, "tmdb_proxy_upstream_circuit_state"))
+
+	var openErr *CircuitOpenError
+	require.ErrorAs(t, call(http.StatusOK, time.Millisecond, nil), &openErr)
+	assert.NotZero(t, openErr.RetryAfter)
+
+	// after the timeout, probes are let through. A failed probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, call(http.StatusInternalServerError, time.Millisecond, nil))
+	state, _ = b.State()
+	assert.Equal(t, "open", state)
+
+	// successful probes close it
+	time.Sleep(60 * time.Millisecond)
+	done1, err := b.allow()
+	require.NoError(t, err)
+	done2, err := b.allow()
+	require.NoError(t, err)
+	_, err = b.allow()
+	require.ErrorAs(t, err, &openErr, "only Probes calls are let through")
+	state, _ = b.State()
+	assert.Equal(t, "half-open", state)
+	done1(http.StatusOK, time.Millisecond, nil)
+	done2(http.StatusNotFound, time.Millisecond, nil)
+	state, _ = b.State()
+	assert.Equal(t, "closed", state)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(b.opened))
+	assert.Equal(t, 2.0, testutil.ToFloat64(b.rejected))
+}
+
+func TestTMDBProxyHandler_CircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+
+	backend := NewMemoryCache(0, 0)
+	breaker := NewCircuitBreaker(0.5, 0, time.Hour, "", "", nil)
+	breaker.MinCalls = 2
+	h := TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Millisecond, StaleIfError: time.Hour, Breaker: breaker}, discardLogger)
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, get("/3/movie/1").Code)
+	time.Sleep(10 * time.Millisecond)
+
+	failing.Store(true)
+	assert.Equal(t, "STALE", get("/3/movie/1").Header().Get("X-Cache"))
+	assert.Equal(t, int32(2), calls.Load())
+
+	// the breaker is open: tmdb is no longer called. Stale responses are still served
+	w := get("/3/movie/2")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	w = get("/3/movie/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+	assert.Equal(t, int32(2), calls.Load())
+
+	w = httptest.NewRecorder()
+	HealthHandler(backend, nil, breaker, discardLogger).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"circuit":"open"`)
+}
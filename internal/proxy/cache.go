@@ -1,43 +1,118 @@
 package proxy
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"github.com/redis/go-redis/v9"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
-	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// ErrNotFound is returned by a Backend if the key is not in the cache, or has expired.
+var ErrNotFound = errors.New("not found")
+
+// Backend stores the proxy's cached responses. See MemoryCache, DiskCache and RedisCache for implementations.
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	Ping(ctx context.Context) error
+}
+
+// AdminBackend is a Backend whose entries can be listed and removed, as used by AdminHandler.
+type AdminBackend interface {
+	Backend
+	Delete(ctx context.Context, key string) error
+	// Scan calls f for every key that starts with prefix. If f returns an error, Scan stops and returns the error.
+	Scan(ctx context.Context, prefix string, f func(key string) error) error
+	Stats(ctx context.Context) (BackendStats, error)
+}
+
+// BackendStats reports the number of entries in the backend and the memory or disk space they use.
+type BackendStats struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
 type responseCache struct {
 	Namespace string
-	Client    RedisClient
+	Backend   Backend
+	// Vary lists the request headers whose values are part of the key, e.g. Accept-Language.
+	Vary []string
 }
 
-type RedisClient interface {
-	Set(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd
-	Get(ctx context.Context, key string) *redis.StringCmd
-	Ping(ctx context.Context) *redis.StatusCmd
+func (c *responseCache) Set(ctx context.Context, req *http.Request, entry cachedResponse, expiration time.Duration) error {
+	return c.Backend.Set(ctx, c.getKey(req), entry.marshal(), expiration)
 }
 
-func (c *responseCache) Set(ctx context.Context, req *http.Request, resp *http.Response, expiration time.Duration) error {
-	buf, err := httputil.DumpResponse(resp, true)
-	if err == nil {
-		err = c.Client.Set(ctx, c.getKey(req), string(buf), expiration).Err()
+func (c *responseCache) Get(ctx context.Context, req *http.Request) (cachedResponse, error) {
+	body, err := c.Backend.Get(ctx, c.getKey(req))
+	if err != nil {
+		return cachedResponse{}, err
 	}
-	return err
+	var entry cachedResponse
+	err = entry.unmarshal(body)
+	return entry, err
 }
 
-func (c *responseCache) Get(ctx context.Context, req *http.Request) (*http.Response, error) {
-	body, err := c.Client.Get(ctx, c.getKey(req)).Result()
-	if err != nil {
-		return nil, err
-	}
-	r := bufio.NewReader(bytes.NewReader([]byte(body)))
-	return http.ReadResponse(r, req)
+// defaultQuery holds the parameters that TMDB assumes if they are not in the request. Requests with and without
+// these parameters get the same response, so they share the same key.
+var defaultQuery = url.Values{
+	"language":      {"en-US"},
+	"include_adult": {"false"},
 }
 
+// getKey returns the key of the request's response. Equivalent requests get the same key: the query parameters
+// are sorted, default parameters are added and the client's api_key is removed. If Vary is set, the values of
+// those headers are added to the key.
 func (c *responseCache) getKey(r *http.Request) string {
-	return c.Namespace + "|" + r.Method + "|" + r.URL.String()
+	query := r.URL.Query()
+	query.Del("api_key")
+	for param, value := range defaultQuery {
+		if !query.Has(param) {
+			query[param] = value
+		}
+	}
+	var key strings.Builder
+	key.WriteString(c.Namespace + "|" + r.Method + "|" + r.URL.EscapedPath() + "?" + query.Encode())
+	for _, header := range c.Vary {
+		key.WriteString("|" + strings.ToLower(header) + "=" + strings.Join(r.Header.Values(header), ","))
+	}
+	return key.String()
+}
+
+var _ prometheus.Collector = &NegativeCacheMetrics{}
+
+// NegativeCacheMetrics counts the cache hits that served a negative response (e.g. a cached 404). Options.CacheMetrics
+// records these as ordinary hits: NegativeCacheMetrics tells them apart.
+type NegativeCacheMetrics struct {
+	hits *prometheus.CounterVec
+}
+
+func NewNegativeCacheMetrics(namespace, subsystem string, constLabels prometheus.Labels) *NegativeCacheMetrics {
+	return &NegativeCacheMetrics{
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "http_cache_negative_hit_total",
+			Help:        "Number of times a negative response was served from the cache",
+			ConstLabels: constLabels,
+		}, []string{"method", "code"}),
+	}
+}
+
+func (m *NegativeCacheMetrics) measure(r *http.Request, statusCode int) {
+	if m != nil {
+		m.hits.WithLabelValues(r.Method, strconv.Itoa(statusCode)).Inc()
+	}
+}
+
+func (m *NegativeCacheMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.hits.Describe(ch)
+}
+
+func (m *NegativeCacheMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.hits.Collect(ch)
 }
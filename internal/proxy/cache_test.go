@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"context"
+	"github.com/clambin/go-common/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackends(t *testing.T) {
+	diskCache, err := NewDiskCache(t.TempDir())
+	require.NoError(t, err)
+
+	backends := map[string]Backend{
+		"memory": NewMemoryCache(0, 0),
+		"disk":   diskCache,
+		"redis":  NewRedisCache(&fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)}),
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			_, err := backend.Get(ctx, "foo")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, backend.Set(ctx, "foo", []byte("bar"), time.Hour))
+			value, err := backend.Get(ctx, "foo")
+			require.NoError(t, err)
+			assert.Equal(t, "bar", string(value))
+
+			require.NoError(t, backend.Set(ctx, "foo", []byte("snafu"), time.Hour))
+			value, err = backend.Get(ctx, "foo")
+			require.NoError(t, err)
+			assert.Equal(t, "snafu", string(value))
+
+			require.NoError(t, backend.Set(ctx, "expired", []byte("bar"), time.Millisecond))
+			time.Sleep(10 * time.Millisecond)
+			_, err = backend.Get(ctx, "expired")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			ttl, err := backend.(ttlBackend).TTL(ctx, "foo")
+			require.NoError(t, err)
+			assert.InDelta(t, time.Hour, ttl, float64(time.Minute))
+			require.NoError(t, backend.Set(ctx, "forever", []byte("bar"), 0))
+			ttl, err = backend.(ttlBackend).TTL(ctx, "forever")
+			require.NoError(t, err)
+			assert.Zero(t, ttl)
+			_, err = backend.(ttlBackend).TTL(ctx, "expired")
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = backend.(ttlBackend).TTL(ctx, "missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			assert.NoError(t, backend.Ping(ctx))
+		})
+	}
+}
+
+func TestAdminBackends(t *testing.T) {
+	diskCache, err := NewDiskCache(t.TempDir())
+	require.NoError(t, err)
+
+	backends := map[string]AdminBackend{
+		"memory": NewMemoryCache(0, 0),
+		"disk":   diskCache,
+		"redis":  NewRedisCache(&fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)}),
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			for _, key := range []string{"a|/3/movie/1", "a|/3/movie/1?language=en-US", "a|/3/movie/12", "a|/3/person/1", "b|/3/movie/1"} {
+				require.NoError(t, backend.Set(ctx, key, []byte("value"), time.Hour))
+			}
+
+			stats, err := backend.Stats(ctx)
+			require.NoError(t, err)
+			// RedisCache only counts the proxy's keys: see TestRedisCache_Stats
+			if name != "redis" {
+				assert.Equal(t, 5, stats.Keys)
+			}
+			assert.NotZero(t, stats.Bytes)
+
+			var keys []string
+			require.NoError(t, backend.Scan(ctx, "a|/3/movie/1?", func(key string) error {
+				keys = append(keys, key)
+				return nil
+			}))
+			assert.Equal(t, []string{"a|/3/movie/1?language=en-US"}, keys)
+
+			keys = nil
+			require.NoError(t, backend.Scan(ctx, "a|/3/movie/", func(key string) error {
+				keys = append(keys, key)
+				return backend.Delete(ctx, key)
+			}))
+			assert.Len(t, keys, 3)
+
+			_, err = backend.Get(ctx, "a|/3/movie/1")
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = backend.Get(ctx, "a|/3/person/1")
+			assert.NoError(t, err)
+
+			assert.NoError(t, backend.Delete(ctx, "missing"))
+		})
+	}
+}
+
+func TestRedisCache_Stats(t *testing.T) {
+	backend := NewRedisCache(&fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)})
+	ctx := context.Background()
+	for _, key := range []string{cacheNamespace + "|GET|/3/movie/1", cacheNamespace + "|GET|/3/movie/2", "other|GET|/3/movie/1", "session:1234"} {
+		require.NoError(t, backend.Set(ctx, key, []byte("value"), time.Hour))
+	}
+
+	// keys of other applications sharing the database aren't counted
+	stats, err := backend.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, BackendStats{Keys: 2, Bytes: 1024}, stats)
+}
+
+func TestMemoryCache_Eviction(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("entries", func(t *testing.T) {
+		c := NewMemoryCache(2, 0)
+		require.NoError(t, c.Set(ctx, "a", []byte("a"), 0))
+		require.NoError(t, c.Set(ctx, "b", []byte("b"), 0))
+		_, err := c.Get(ctx, "a")
+		require.NoError(t, err)
+		require.NoError(t, c.Set(ctx, "c", []byte("c"), 0))
+
+		// b is the least recently used entry
+		_, err = c.Get(ctx, "b")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = c.Get(ctx, "a")
+		assert.NoError(t, err)
+		count, size := c.Len()
+		assert.Equal(t, 2, count)
+		assert.Equal(t, int64(4), size)
+	})
+
+	t.Run("size", func(t *testing.T) {
+		c := NewMemoryCache(0, 10)
+		require.NoError(t, c.Set(ctx, "a", []byte("1234"), 0))
+		require.NoError(t, c.Set(ctx, "b", []byte("1234"), 0))
+		require.NoError(t, c.Set(ctx, "c", []byte("1234"), 0))
+		count, size := c.Len()
+		assert.Equal(t, 2, count)
+		assert.Equal(t, int64(10), size)
+
+		// entries larger than the cache are not stored
+		require.NoError(t, c.Set(ctx, "d", []byte("12345678910"), 0))
+		_, err := c.Get(ctx, "d")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestResponseCache_getKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		vary   []string
+		want   string
+	}{
+		{name: "defaults", target: "/3/movie/550", want: "ns|GET|/3/movie/550?include_adult=false&language=en-US"},
+		{name: "sorted", target: "/3/search/person?query=tom&page=2&language=en-US", want: "ns|GET|/3/search/person?include_adult=false&language=en-US&page=2&query=tom"},
+		{name: "api key", target: "/3/movie/550?api_key=secret&language=fr-FR", want: "ns|GET|/3/movie/550?include_adult=false&language=fr-FR"},
+		{name: "escaped", target: "/3/search/person?query=tom+hanks", want: "ns|GET|/3/search/person?include_adult=false&language=en-US&query=tom+hanks"},
+		{name: "vary", target: "/3/movie/550", header: http.Header{"Accept-Language": {"fr"}}, vary: []string{"Accept-Language", "X-Foo"}, want: "ns|GET|/3/movie/550?include_adult=false&language=en-US|accept-language=fr|x-foo="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := responseCache{Namespace: "ns", Vary: tt.vary}
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header = tt.header
+			if r.Header == nil {
+				r.Header = make(http.Header)
+			}
+			assert.Equal(t, tt.want, c.getKey(r))
+		})
+	}
+}
+
+func TestDiskCache_Sweep(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, c.Set(ctx, "fresh", []byte("value"), time.Hour))
+	require.NoError(t, c.Set(ctx, "forever", []byte("value"), 0))
+	require.NoError(t, c.Set(ctx, "expired", []byte("value"), time.Millisecond))
+	require.NoError(t, os.WriteFile(filepath.Join(c.Directory, "corrupt"), []byte("foo"), 0o640))
+	abandoned := filepath.Join(c.Directory, ".tmp-1")
+	require.NoError(t, os.WriteFile(abandoned, []byte("foo"), 0o640))
+	require.NoError(t, os.Chtimes(abandoned, time.Time{}, time.Now().Add(-2*time.Hour)))
+	require.NoError(t, os.WriteFile(filepath.Join(c.Directory, ".tmp-2"), []byte("foo"), 0o640))
+	time.Sleep(10 * time.Millisecond)
+
+	removed, err := c.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+
+	for _, key := range []string{"fresh", "forever"} {
+		_, err = c.Get(ctx, key)
+		assert.NoError(t, err, key)
+	}
+	assert.NoFileExists(t, abandoned)
+	assert.FileExists(t, filepath.Join(c.Directory, ".tmp-2"), "writes in progress are not removed")
+}
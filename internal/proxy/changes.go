@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChangesPoller removes cached responses for movies, persons and tv shows that were edited on TMDB. It polls TMDB's
+// changes endpoints every Interval and purges all cached responses for the changed ids (details, credits, images,
+// etc.).
+type ChangesPoller struct {
+	Client   *tmdb.Client
+	Backend  AdminBackend
+	Interval time.Duration
+	Logger   *slog.Logger
+}
+
+// changesMediaTypes are the media types whose changes are polled.
+var changesMediaTypes = []string{"movie", "person", "tv"}
+
+// Run polls for changes until the context is canceled.
+func (p *ChangesPoller) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		now := time.Now()
+		// TMDB reports changes per day: poll from the day of the previous poll, so no changes are missed at midnight
+		if deleted, err := p.Poll(ctx, last, now); err != nil {
+			p.Logger.Warn("failed to purge changed items", "err", err, "deleted", deleted)
+		} else {
+			p.Logger.Debug("changed items purged", "deleted", deleted)
+			last = now
+		}
+	}
+}
+
+// Poll purges the cached responses for all items that changed between start and end. It returns the number of
+// purged responses.
+func (p *ChangesPoller) Poll(ctx context.Context, start, end time.Time) (int, error) {
+	changed := make(map[string]struct{})
+	for _, mediaType := range changesMediaTypes {
+		changes, err := p.Client.Changes(mediaType, start, end).All(ctx)
+		if err != nil {
+			return 0, err
+		}
+		for _, change := range changes {
+			changed["/3/"+mediaType+"/"+strconv.Itoa(change.Id)] = struct{}{}
+		}
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	// scan the cache once, rather than once per changed item
+	prefix := cacheNamespace + "|" + http.MethodGet + "|"
+	var deleted int
+	err := p.Backend.Scan(ctx, prefix+"/3/", func(key string) error {
+		if _, ok := changed[itemPath(strings.TrimPrefix(key, prefix))]; !ok {
+			return nil
+		}
+		if err := p.Backend.Delete(ctx, key); err != nil {
+			return err
+		}
+		deleted++
+		return nil
+	})
+	return deleted, err
+}
+
+// itemPath returns the path of the item that a URL refers to, e.g. "/3/movie/550" for "/3/movie/550/credits?language=en".
+func itemPath(url string) string {
+	// "/3/movie/550/credits?language=en" -> ["", "3", "movie", "550/credits?language=en"]
+	parts := strings.SplitN(url, "/", 4)
+	if len(parts) < 4 {
+		return ""
+	}
+	id := parts[3]
+	if i := strings.IndexAny(id, "/?"); i >= 0 {
+		id = id[:i]
+	}
+	return "/3/" + parts[2] + "/" + id
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChangesPoller(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/3/movie/changes":
+			_, _ = w.Write([]byte(`{"results":[{"id":550}],"page":1,"total_pages":1,"total_results":1}`))
+		case "/3/person/changes":
+			_, _ = w.Write([]byte(`{"results":[{"id":287}],"page":1,"total_pages":1,"total_results":1}`))
+		case "/3/tv/changes":
+			_, _ = w.Write([]byte(`{"results":[],"page":1,"total_pages":1,"total_results":0}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	backend := NewMemoryCache(0, 0)
+	h := TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Hour}, discardLogger)
+	for _, target := range []string{"/3/movie/550", "/3/movie/550/credits", "/3/movie/550?language=en-US", "/3/movie/5500", "/3/person/287/images", "/3/person/2870", "/3/tv/550"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	c := tmdb.New("", &http.Client{Transport: UpstreamTransport{Upstream: NewUpstreamLimiter(0, 0, 0, "", "", nil)}})
+	c.BaseURL = s.URL
+	p := ChangesPoller{Client: c, Backend: backend, Logger: discardLogger}
+	deleted, err := p.Poll(t.Context(), time.Now().Add(-time.Hour), time.Now())
+	require.NoError(t, err)
+	// "/3/movie/550" and "/3/movie/550?language=en-US" share the same entry
+	assert.Equal(t, 3, deleted)
+
+	responses := responseCache{Namespace: cacheNamespace, Backend: backend}
+	for target, wantCached := range map[string]bool{
+		"/3/movie/550":         false,
+		"/3/movie/550/credits": false,
+		"/3/movie/5500":        true,
+		"/3/person/287/images": false,
+		"/3/person/2870":       true,
+		"/3/tv/550":            true,
+	} {
+		_, err = responses.Get(t.Context(), httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, wantCached, err == nil, target)
+	}
+}
+
+func TestChangesPoller_Upstream(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	t.Cleanup(s.Close)
+
+	// the poller's calls count against the proxy's upstream limit, and honour TMDB's throttling
+	upstream := NewUpstreamLimiter(0, 0, time.Second, "", "", nil)
+	c := tmdb.New("", &http.Client{Transport: UpstreamTransport{Upstream: upstream, Breaker: NewCircuitBreaker(0.5, 0, time.Hour, "", "", nil)}})
+	c.BaseURL = s.URL
+	p := ChangesPoller{Client: c, Backend: NewMemoryCache(0, 0), Logger: discardLogger}
+
+	_, err := p.Poll(t.Context(), time.Now().Add(-time.Hour), time.Now())
+	var throttled *ThrottledError
+	require.ErrorAs(t, err, &throttled)
+	assert.Greater(t, upstream.BackoffRemaining(), 50*time.Second)
+
+	_, err = p.Poll(t.Context(), time.Now().Add(-time.Hour), time.Now())
+	require.ErrorAs(t, err, &throttled)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestItemPath(t *testing.T) {
+	assert.Equal(t, "/3/movie/550", itemPath("/3/movie/550"))
+	assert.Equal(t, "/3/movie/550", itemPath("/3/movie/550?language=en-US"))
+	assert.Equal(t, "/3/tv/1399", itemPath("/3/tv/1399/season/1/episode/1"))
+	assert.Equal(t, "", itemPath("/3/configuration"))
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// addValidators adds an ETag to the response, if TMDB didn't provide one, so clients can make conditional requests.
+// A Last-Modified header is only sent if TMDB provided one: the time the proxy fetched the response says nothing about
+// when the data last changed.
+func addValidators(resp *http.Response) error {
+	if resp.Header.Get("ETag") != "" {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	hash := sha256.Sum256(body)
+	// the same ETag is used for the compressed and decompressed response: it's a weak validator
+	resp.Header.Set("ETag", `W/"`+hex.EncodeToString(hash[:16])+`"`)
+	return nil
+}
+
+// validators returns the headers to revalidate the cached response with TMDB.
+func (e cachedResponse) validators() http.Header {
+	h := make(http.Header)
+	if etag := e.header.Get("ETag"); etag != "" {
+		h.Set("If-None-Match", etag)
+	}
+	if lastModified := e.header.Get("Last-Modified"); lastModified != "" {
+		h.Set("If-Modified-Since", lastModified)
+	}
+	return h
+}
+
+// renew returns a copy of the cached response that is fresh for ttl.
+func (e cachedResponse) renew(ttl time.Duration) cachedResponse {
+	now := time.Now()
+	e.storedAt = now
+	e.freshUntil = now.Add(ttl)
+	return e
+}
+
+// ttl returns how long the cached response remains fresh.
+func (e cachedResponse) ttl() time.Duration {
+	return max(0, time.Until(e.freshUntil))
+}
+
+// notModified reports whether the request's conditional headers match the response's validators.
+func notModified(r *http.Request, h http.Header) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, h.Get("ETag"))
+	}
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		lastModified, err := http.ParseTime(h.Get("Last-Modified"))
+		return err == nil && !lastModified.After(since)
+	}
+	return false
+}
+
+// etagMatches reports whether the ETag is in the list of an If-None-Match header, using weak comparison.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTMDBProxyHandler_Conditional(t *testing.T) {
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/3/movie/550" {
+			w.Header().Set("Last-Modified", lastModified)
+		}
+		_, _ = w.Write([]byte(`{"id":550}`))
+	}))
+	t.Cleanup(s.Close)
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Hour}, discardLogger)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/550", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "public, max-age=3599", w.Header().Get("Cache-Control"))
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Equal(t, lastModified, w.Header().Get("Last-Modified"))
+
+	// if TMDB doesn't send a Last-Modified header, the proxy doesn't make one up
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/551", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	assert.Empty(t, w.Header().Get("Last-Modified"))
+	r := httptest.NewRequest(http.MethodGet, "/3/movie/551", nil)
+	r.Header.Set("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	tests := []struct {
+		name           string
+		header         http.Header
+		wantStatusCode int
+	}{
+		{name: "unconditional", wantStatusCode: http.StatusOK},
+		{name: "etag matches", header: http.Header{"If-None-Match": {`"foo", ` + etag}}, wantStatusCode: http.StatusNotModified},
+		{name: "weak comparison", header: http.Header{"If-None-Match": {strings.TrimPrefix(etag, "W/")}}, wantStatusCode: http.StatusNotModified},
+		{name: "any etag", header: http.Header{"If-None-Match": {"*"}}, wantStatusCode: http.StatusNotModified},
+		{name: "etag doesn't match", header: http.Header{"If-None-Match": {`"foo"`}}, wantStatusCode: http.StatusOK},
+		{name: "not modified since", header: http.Header{"If-Modified-Since": {lastModified}}, wantStatusCode: http.StatusNotModified},
+		{name: "modified since", header: http.Header{"If-Modified-Since": {"Sun, 01 Jan 2006 15:04:05 GMT"}}, wantStatusCode: http.StatusOK},
+		{name: "etag takes precedence", header: http.Header{"If-None-Match": {`"foo"`}, "If-Modified-Since": {lastModified}}, wantStatusCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/3/movie/550", nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantStatusCode, w.Code)
+			assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+			if tt.wantStatusCode == http.StatusNotModified {
+				assert.Empty(t, w.Body.String())
+			} else {
+				assert.Equal(t, `{"id":550}`, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTMDBProxyHandler_Revalidate(t *testing.T) {
+	var full, notModified atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `W/"v1"`)
+		if r.Header.Get("If-None-Match") == `W/"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		_, _ = w.Write([]byte(`{"id":550}`))
+	}))
+	t.Cleanup(s.Close)
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: 10 * time.Millisecond, StaleIfError: time.Hour}, discardLogger)
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/3/movie/550", nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// the client's validators are not forwarded to TMDB
+	w := get(http.Header{"If-None-Match": {`W/"v1"`}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, int32(1), full.Load())
+
+	// the stale entry is revalidated with TMDB
+	time.Sleep(20 * time.Millisecond)
+	w = get(http.Header{})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"id":550}`, w.Body.String())
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, int32(1), full.Load())
+	assert.Equal(t, int32(1), notModified.Load())
+
+	// the renewed entry is fresh again
+	w = get(http.Header{})
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, `{"id":550}`, w.Body.String())
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var _ AdminBackend = &DiskCache{}
+
+// DiskCache stores cached responses as files in a directory tree. Each entry is stored in its own file, named after
+// the hash of its key. The file holds the entry's expiry time, its key and its value. Expired entries are removed
+// when they are read, or by Sweep, so entries that are never read again don't fill up the disk.
+type DiskCache struct {
+	Directory string
+}
+
+func NewDiskCache(directory string) (*DiskCache, error) {
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return nil, err
+	}
+	return &DiskCache{Directory: directory}, nil
+}
+
+func (d *DiskCache) Get(_ context.Context, key string) ([]byte, error) {
+	path := d.path(key)
+	body, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	storedKey, value, expired, ok := parseDiskEntry(body)
+	if !ok || expired || storedKey != key {
+		_ = os.Remove(path)
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// parseDiskEntry parses the contents of a file: an 8-byte expiry time, the 4-byte length of the key, the key and
+// the value.
+func parseDiskEntry(body []byte) (key string, value []byte, expired bool, ok bool) {
+	if len(body) < 12 {
+		return "", nil, false, false
+	}
+	expiry := int64(binary.BigEndian.Uint64(body))
+	keyLen := int(binary.BigEndian.Uint32(body[8:]))
+	if keyLen > len(body)-12 {
+		return "", nil, false, false
+	}
+	return string(body[12 : 12+keyLen]), body[12+keyLen:], expiry != 0 && time.Now().UnixNano() > expiry, true
+}
+
+// TTL returns the time until the key expires, or 0 if it never expires.
+func (d *DiskCache) TTL(_ context.Context, key string) (time.Duration, error) {
+	f, err := os.Open(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	var header [8]byte
+	if _, err = io.ReadFull(f, header[:]); err != nil {
+		return 0, ErrNotFound
+	}
+	expiry := int64(binary.BigEndian.Uint64(header[:]))
+	if expiry == 0 {
+		return 0, nil
+	}
+	remaining := time.Until(time.Unix(0, expiry))
+	if remaining <= 0 {
+		return 0, ErrNotFound
+	}
+	return remaining, nil
+}
+
+func (d *DiskCache) Set(_ context.Context, key string, value []byte, expiration time.Duration) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	var expiry int64
+	if expiration > 0 {
+		expiry = time.Now().Add(expiration).UnixNano()
+	}
+	body := binary.BigEndian.AppendUint64(make([]byte, 0, 12+len(key)+len(value)), uint64(expiry))
+	body = binary.BigEndian.AppendUint32(body, uint32(len(key)))
+	body = append(body, key...)
+	body = append(body, value...)
+
+	// write to a temporary file first, so readers never see a partially written entry
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(body)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (d *DiskCache) Delete(_ context.Context, key string) error {
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Scan reads every file in the cache, so it is slow for large caches.
+func (d *DiskCache) Scan(ctx context.Context, prefix string, f func(key string) error) error {
+	return d.walk(ctx, func(key string, _ int64) error {
+		if strings.HasPrefix(key, prefix) {
+			return f(key)
+		}
+		return nil
+	})
+}
+
+func (d *DiskCache) Stats(ctx context.Context) (BackendStats, error) {
+	var stats BackendStats
+	err := d.walk(ctx, func(_ string, size int64) error {
+		stats.Keys++
+		stats.Bytes += size
+		return nil
+	})
+	return stats, err
+}
+
+// walk calls f with the key and file size of every entry that hasn't expired.
+func (d *DiskCache) walk(ctx context.Context, f func(key string, size int64) error) error {
+	return filepath.WalkDir(d.Directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		body, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		key, _, expired, ok := parseDiskEntry(body)
+		if !ok || expired {
+			return nil
+		}
+		return f(key, int64(len(body)))
+	})
+}
+
+// Run removes expired entries every interval, until the context is canceled.
+func (d *DiskCache) Run(ctx context.Context, interval time.Duration, logger *slog.Logger) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if removed, err := d.Sweep(ctx); err != nil {
+			logger.Warn("failed to remove expired disk cache entries", "err", err, "removed", removed)
+		} else {
+			logger.Debug("expired disk cache entries removed", "removed", removed)
+		}
+	}
+}
+
+// Sweep removes all expired and unreadable entries, and temporary files left behind by interrupted writes. It returns
+// the number of removed files.
+func (d *DiskCache) Sweep(ctx context.Context) (int, error) {
+	var removed int
+	err := filepath.WalkDir(d.Directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".tmp-") {
+			// a write may still be in progress
+			if info, err := entry.Info(); err != nil || time.Since(info.ModTime()) < time.Hour {
+				return nil
+			}
+		} else {
+			body, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			if _, _, expired, ok := parseDiskEntry(body); ok && !expired {
+				return nil
+			}
+		}
+		if err = os.Remove(path); err == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+func (d *DiskCache) Ping(_ context.Context) error {
+	_, err := os.Stat(d.Directory)
+	return err
+}
+
+func (d *DiskCache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(hash[:])
+	return filepath.Join(d.Directory, name[:2], name[2:])
+}
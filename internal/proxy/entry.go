@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cachedResponse is a response stored in the cache. The response is fresh until freshUntil. After that, it is stale,
+// but may still be served while it is being refreshed, or when TMDB is unavailable.
+//
+// The body is stored gzip-compressed: it is sent as-is to clients that accept gzip, re-encoded for clients that only
+// accept br, and only decompressed for clients that accept neither.
+type cachedResponse struct {
+	storedAt   time.Time
+	freshUntil time.Time
+	statusCode int
+	header     http.Header
+	body       []byte
+	gzipped    bool
+}
+
+// cachedResponseVersion is the version of the envelope of a marshalled cachedResponse:
+//
+//	version (1 byte) | storedAt (8 bytes) | freshUntil (8 bytes) | statusCode (uvarint) | flags (1 byte) |
+//	header count (uvarint) | (name length (uvarint) | name | value length (uvarint) | value)... | body
+const cachedResponseVersion = 2
+
+const flagGzipped = 1 << 0
+
+func newCachedResponse(resp *http.Response, ttl time.Duration) (cachedResponse, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return cachedResponse{}, err
+	}
+	now := time.Now()
+	entry := cachedResponse{
+		storedAt:   now,
+		freshUntil: now.Add(ttl),
+		statusCode: resp.StatusCode,
+		header:     resp.Header.Clone(),
+		body:       body,
+	}
+	if entry.header == nil {
+		entry.header = make(http.Header)
+	}
+	removeHopByHopHeaders(entry.header)
+	entry.header.Del("Content-Length")
+	switch encoding := entry.header.Get("Content-Encoding"); encoding {
+	case "gzip":
+		entry.gzipped = true
+	case "", "identity":
+		if len(body) > 0 {
+			if entry.body, err = compress(body); err != nil {
+				return cachedResponse{}, err
+			}
+			entry.gzipped = true
+		}
+	default:
+		// we only ask TMDB for gzip. Anything else is stored, and served, as-is.
+		return entry, nil
+	}
+	entry.header.Del("Content-Encoding")
+	return entry, nil
+}
+
+func (e cachedResponse) marshal() []byte {
+	buf := make([]byte, 0, 64+len(e.body))
+	buf = append(buf, cachedResponseVersion)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(e.storedAt.UnixNano()))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(e.freshUntil.UnixNano()))
+	buf = binary.AppendUvarint(buf, uint64(e.statusCode))
+	var flags byte
+	if e.gzipped {
+		flags |= flagGzipped
+	}
+	buf = append(buf, flags)
+	var count int
+	for _, values := range e.header {
+		count += len(values)
+	}
+	buf = binary.AppendUvarint(buf, uint64(count))
+	for name, values := range e.header {
+		for _, value := range values {
+			buf = appendString(buf, name)
+			buf = appendString(buf, value)
+		}
+	}
+	return append(buf, e.body...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+var errInvalidEntry = errors.New("invalid cache entry")
+
+func (e *cachedResponse) unmarshal(buf []byte) error {
+	if len(buf) > 0 && buf[0] != cachedResponseVersion {
+		// entries written by older versions of the proxy are treated as cache misses, so they are replaced
+		return fmt.Errorf("%w: unsupported cache entry version %d", ErrNotFound, buf[0])
+	}
+	if len(buf) < 17 {
+		return errInvalidEntry
+	}
+	e.storedAt = time.Unix(0, int64(binary.BigEndian.Uint64(buf[1:])))
+	e.freshUntil = time.Unix(0, int64(binary.BigEndian.Uint64(buf[9:])))
+	r := envelopeReader{buf: buf[17:]}
+	e.statusCode = int(r.uvarint())
+	e.gzipped = r.byte()&flagGzipped != 0
+	count := r.uvarint()
+	e.header = make(http.Header)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		name, value := r.string(), r.string()
+		e.header[name] = append(e.header[name], value)
+	}
+	e.body = r.buf
+	return r.err
+}
+
+type envelopeReader struct {
+	buf []byte
+	err error
+}
+
+func (r *envelopeReader) uvarint() uint64 {
+	value, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errInvalidEntry
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return value
+}
+
+func (r *envelopeReader) byte() byte {
+	if len(r.buf) < 1 {
+		r.err = errInvalidEntry
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *envelopeReader) string() string {
+	n := r.uvarint()
+	if r.err != nil || n > uint64(len(r.buf)) {
+		r.err = errInvalidEntry
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+func (e cachedResponse) fresh() bool {
+	return time.Now().Before(e.freshUntil)
+}
+
+// staleFor returns how long the entry has been stale.
+func (e cachedResponse) staleFor() time.Duration {
+	return max(0, time.Since(e.freshUntil))
+}
+
+func (e cachedResponse) age() time.Duration {
+	return max(0, time.Since(e.storedAt))
+}
+
+// encodedBody returns the body to send to the client and its Content-Encoding, as negotiated by contentEncoding: the
+// compressed body as stored, the body re-encoded with brotli, or the decompressed body.
+func (e cachedResponse) encodedBody(r *http.Request) ([]byte, string, error) {
+	if !e.gzipped {
+		return e.body, e.header.Get("Content-Encoding"), nil
+	}
+	encoding := contentEncoding(r)
+	if encoding == "gzip" {
+		return e.body, encoding, nil
+	}
+	body, err := e.plainBody()
+	if err != nil || encoding == "" {
+		return body, "", err
+	}
+	var buf bytes.Buffer
+	bw := brotli.NewWriterLevel(&buf, brotliLevel)
+	if _, err = bw.Write(body); err == nil {
+		err = bw.Close()
+	}
+	return buf.Bytes(), encoding, err
+}
+
+// brotliLevel is the compression level for brotli responses. Responses are compressed on every request: higher
+// levels cost more CPU than they save in bandwidth.
+const brotliLevel = 4
+
+// plainBody returns the decompressed body.
+func (e cachedResponse) plainBody() ([]byte, error) {
+	if !e.gzipped {
+		return e.body, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(e.body))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
+
+func compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	err := zw.Close()
+	return buf.Bytes(), err
+}
+
+// contentEncoding returns the encoding to use for the response to the request: "gzip", "br" or "" (identity), as
+// allowed by its Accept-Encoding header. If the client accepts both, gzip is preferred, unless the client prefers br:
+// gzip is how responses are stored, so it needs no re-encoding.
+func contentEncoding(r *http.Request) string {
+	gzipQ, brQ := -1.0, -1.0
+	anyQ := 0.0
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip":
+			gzipQ = q
+		case "br":
+			brQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	// codings that aren't listed get the weight of "*", if present
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if brQ < 0 {
+		brQ = anyQ
+	}
+	switch {
+	case gzipQ > 0 && gzipQ >= brQ:
+		return "gzip"
+	case brQ > 0:
+		return "br"
+	default:
+		return ""
+	}
+}
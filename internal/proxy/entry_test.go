@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedResponse_Marshal(t *testing.T) {
+	body := strings.Repeat(`{"id":550,"title":"Fight Club"}`, 100)
+	resp := http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}, "X-Multi": {"a", "b"}, "Content-Length": {"3100"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	entry, err := newCachedResponse(&resp, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, entry.gzipped)
+	assert.Less(t, len(entry.body), len(body)/10)
+
+	buf := entry.marshal()
+	var got cachedResponse
+	require.NoError(t, got.unmarshal(buf))
+	assert.Equal(t, entry.storedAt.UnixNano(), got.storedAt.UnixNano())
+	assert.Equal(t, entry.freshUntil.UnixNano(), got.freshUntil.UnixNano())
+	assert.Equal(t, http.StatusOK, got.statusCode)
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}, "X-Multi": {"a", "b"}}, got.header)
+	plain, err := got.plainBody()
+	require.NoError(t, err)
+	assert.Equal(t, body, string(plain))
+
+	// truncated entries are invalid
+	assert.Error(t, got.unmarshal(buf[:20]))
+	// entries of older versions are treated as misses
+	assert.ErrorIs(t, got.unmarshal([]byte{1, 2, 3}), ErrNotFound)
+}
+
+func TestContentEncoding(t *testing.T) {
+	for value, want := range map[string]string{
+		"":                     "",
+		"gzip":                 "gzip",
+		"deflate, gzip;q=1.0":  "gzip",
+		"br, GZIP":             "gzip",
+		"*":                    "gzip",
+		"gzip;q=0":             "",
+		"identity":             "",
+		"br":                   "br",
+		"gzip;q=0.5, br":       "br",
+		"gzip;q=0, *":          "br",
+		"br;q=0, gzip;q=0":     "",
+		"br;q=0.1, *;q=0.5":    "gzip",
+		"deflate, br;q=0.8, *": "gzip",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", value)
+		assert.Equal(t, want, contentEncoding(r), value)
+	}
+}
+
+func TestTMDBProxyHandler_Compression(t *testing.T) {
+	body := strings.Repeat(`{"id":550,"title":"Fight Club"}`, 100)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" || r.URL.Path == "/plain" {
+			_, _ = w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		_, _ = zw.Write([]byte(body))
+		_ = zw.Close()
+	}))
+	t.Cleanup(s.Close)
+
+	backend := NewMemoryCache(0, 0)
+	h := TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Hour}, discardLogger)
+
+	for _, path := range []string{"/compressed", "/plain"} {
+		t.Run(path, func(t *testing.T) {
+			for range 2 {
+				// clients that accept gzip get the compressed response
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				r.Header.Set("Accept-Encoding", "gzip")
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				require.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+				assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+				zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+				require.NoError(t, err)
+				plain, err := io.ReadAll(zr)
+				require.NoError(t, err)
+				assert.Equal(t, body, string(plain))
+
+				// clients that only accept br get the response re-encoded
+				r = httptest.NewRequest(http.MethodGet, path, nil)
+				r.Header.Set("Accept-Encoding", "br")
+				w = httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				require.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+				assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+				plain, err = io.ReadAll(brotli.NewReader(bytes.NewReader(w.Body.Bytes())))
+				require.NoError(t, err)
+				assert.Equal(t, body, string(plain))
+
+				// other clients get the decompressed response
+				w = httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				require.Equal(t, http.StatusOK, w.Code)
+				assert.Empty(t, w.Header().Get("Content-Encoding"))
+				assert.Equal(t, body, w.Body.String())
+			}
+		})
+	}
+
+	// entries are stored compressed
+	_, size := backend.Len()
+	assert.Less(t, size, int64(len(body)/2))
+}
@@ -0,0 +1,258 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	"golang.org/x/sync/singleflight"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImageOptions configure the ImageHandler.
+type ImageOptions struct {
+	// BaseURL is TMDB's image server. Defaults to https://image.tmdb.org.
+	BaseURL string
+	// Resize allows widths that TMDB doesn't offer (e.g. "w400"). These are resized from the next larger width that
+	// TMDB does offer.
+	Resize bool
+	// MaxWidth is the largest width that Resize produces. Defaults to 2000.
+	MaxWidth int
+	// Upstream, if set, limits the calls to TMDB's image server.
+	Upstream *UpstreamLimiter
+	// Limiter, if set, limits the requests per client, as for the API.
+	Limiter *ClientLimiter
+	// ClientMetrics, if set, records the requests of each client.
+	ClientMetrics *ClientMetrics
+	// Offline never calls TMDB: images that aren't cached return 504 Gateway Timeout.
+	Offline bool
+}
+
+// tmdbWidths are the widths offered by TMDB, across poster, backdrop, profile and logo images.
+var tmdbWidths = []int{45, 92, 154, 185, 300, 342, 500, 780, 1280}
+
+// ImageHandler serves TMDB images, i.e. /t/p/{size}/{path}, caching them in an ImageCache. Sizes other than the
+// widths offered by TMDB, "original" and, if Resize is set, widths up to MaxWidth are rejected.
+func ImageHandler(cache *ImageCache, options ImageOptions, logger *slog.Logger) http.Handler {
+	h := imageHandler{
+		cache:   cache,
+		options: options,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		logger:  logger,
+	}
+	m := http.NewServeMux()
+	m.Handle("GET /t/p/{size}/{path}", &h)
+	return m
+}
+
+type imageHandler struct {
+	cache    *ImageCache
+	options  ImageOptions
+	client   *http.Client
+	logger   *slog.Logger
+	inflight singleflight.Group
+}
+
+func (h *imageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	size, name := r.PathValue("size"), r.PathValue("path")
+	if name == "" || strings.Contains(name, "..") || !h.validSize(size) {
+		http.NotFound(w, r)
+		return
+	}
+	key := "/t/p/" + size + "/" + name
+
+	client := clientName(r)
+	if h.options.Limiter != nil {
+		if ok, retryAfter := h.options.Limiter.allowRequest(client); !ok {
+			h.options.ClientMetrics.rateLimited(r, "requests")
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+	}
+
+	result := "hit"
+	var body []byte
+	f, err := h.cache.Open(key)
+	if errors.Is(err, ErrNotFound) {
+		result = "miss"
+		if h.options.Offline {
+			h.options.ClientMetrics.measure(r, result)
+			http.Error(w, "not in cache and proxy is offline", http.StatusGatewayTimeout)
+			return
+		}
+		if h.options.Limiter != nil {
+			if ok, retryAfter := h.options.Limiter.allowUpstream(client); !ok {
+				h.options.ClientMetrics.rateLimited(r, "upstream")
+				writeTooManyRequests(w, retryAfter)
+				return
+			}
+		}
+		var fetched any
+		if fetched, err, _ = h.inflight.Do(key, func() (any, error) {
+			return h.fetch(context.WithoutCancel(r.Context()), size, name, key)
+		}); err == nil {
+			body = fetched.([]byte)
+			if f, err = h.cache.Open(key); errors.Is(err, ErrNotFound) {
+				// the image couldn't be cached (e.g. it's larger than the cache): serve it from memory
+				err = nil
+			}
+		}
+	}
+	h.options.ClientMetrics.measure(r, result)
+	var statusErr *imageStatusError
+	switch {
+	case errors.As(err, &statusErr):
+		http.Error(w, http.StatusText(statusErr.statusCode), statusErr.statusCode)
+		return
+	case err != nil:
+		if retryAfter, ok := isThrottled(err); ok {
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+		h.logger.Warn("failed to get image", "err", err, "image", key)
+		http.Error(w, "failed to get image", http.StatusBadGateway)
+		return
+	}
+	var content io.ReadSeeker = bytes.NewReader(body)
+	var modTime time.Time
+	if f != nil {
+		defer func() { _ = f.Close() }()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, "failed to get image", http.StatusInternalServerError)
+			return
+		}
+		content, modTime = f, info.ModTime()
+	}
+
+	// images never change: the key is a valid strong validator
+	hash := sha256.Sum256([]byte(key))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:16])+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	// ServeContent handles Range, If-None-Match and If-Modified-Since requests
+	http.ServeContent(w, r, name, modTime, content)
+}
+
+type imageStatusError struct {
+	statusCode int
+}
+
+func (e *imageStatusError) Error() string {
+	return "tmdb returned " + strconv.Itoa(e.statusCode)
+}
+
+// fetch gets the image from TMDB and stores it in the cache. It returns the image, even if it couldn't be cached.
+func (h *imageHandler) fetch(ctx context.Context, size, name, key string) ([]byte, error) {
+	width, resize := h.resizeWidth(size)
+	source := size
+	if resize {
+		source = sourceSize(width)
+	}
+	body, err := h.get(ctx, source, name)
+	if err == nil && resize {
+		body, err = resizeImage(body, width)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = h.cache.Store(key, body); err != nil && !errors.Is(err, ErrImageTooLarge) {
+		h.logger.Warn("failed to cache image", "err", err, "image", key)
+	}
+	return body, nil
+}
+
+func (h *imageHandler) get(ctx context.Context, size, name string) ([]byte, error) {
+	if h.options.Upstream != nil {
+		if err := h.options.Upstream.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	target := cmp.Or(h.options.BaseURL, "https://image.tmdb.org") + "/t/p/" + size + "/" + name
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &imageStatusError{statusCode: resp.StatusCode}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// validSize reports whether size is a width offered by TMDB (e.g. "w500"), "original" or, if Resize is set, a width
+// up to MaxWidth.
+func (h *imageHandler) validSize(size string) bool {
+	if size == "original" {
+		return true
+	}
+	width, err := strconv.Atoi(strings.TrimPrefix(size, "w"))
+	if err != nil || size != "w"+strconv.Itoa(width) {
+		return false
+	}
+	if slices.Contains(tmdbWidths, width) {
+		return true
+	}
+	_, resize := h.resizeWidth(size)
+	return resize
+}
+
+// resizeWidth returns the width to resize the image to, if size is a width that TMDB doesn't offer.
+func (h *imageHandler) resizeWidth(size string) (int, bool) {
+	if !h.options.Resize {
+		return 0, false
+	}
+	width, err := strconv.Atoi(strings.TrimPrefix(size, "w"))
+	if !strings.HasPrefix(size, "w") || err != nil || width <= 0 || width > cmp.Or(h.options.MaxWidth, 2000) {
+		return 0, false
+	}
+	return width, !slices.Contains(tmdbWidths, width)
+}
+
+// sourceSize returns the smallest size offered by TMDB that is at least width wide.
+func sourceSize(width int) string {
+	for _, w := range tmdbWidths {
+		if w >= width {
+			return "w" + strconv.Itoa(w)
+		}
+	}
+	return "original"
+}
+
+// resizeImage scales a JPEG or PNG image to the width, keeping its aspect ratio. Images that are already smaller
+// are returned unchanged.
+func resizeImage(body []byte, width int) ([]byte, error) {
+	src, format, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		return body, nil
+	}
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	switch format {
+	case "png":
+		err = png.Encode(&buf, dst)
+	default:
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	}
+	return buf.Bytes(), err
+}
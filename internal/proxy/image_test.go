@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestImageCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewImageCache(dir, 10)
+	require.NoError(t, err)
+
+	_, err = c.Open("/t/p/w92/a.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, c.Store("/t/p/w92/a.jpg", []byte("1234")))
+	require.NoError(t, c.Store("/t/p/w92/b.jpg", []byte("1234")))
+	f, err := c.Open("/t/p/w92/a.jpg")
+	require.NoError(t, err)
+	_ = f.Close()
+
+	// images larger than the cache aren't stored
+	assert.ErrorIs(t, c.Store("/t/p/w92/large.jpg", []byte("12345678901")), ErrImageTooLarge)
+	_, err = c.Open("/t/p/w92/large.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// b is the least recently used image
+	require.NoError(t, c.Store("/t/p/w92/c.jpg", []byte("1234")))
+	_, err = c.Open("/t/p/w92/b.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+	count, size := c.Len()
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(8), size)
+
+	// a new cache picks up the existing images
+	c, err = NewImageCache(dir, 10)
+	require.NoError(t, err)
+	count, size = c.Len()
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(8), size)
+	f, err = c.Open("/t/p/w92/c.jpg")
+	require.NoError(t, err)
+	_ = f.Close()
+}
+
+func TestImageHandler(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 500, 750))))
+	poster := buf.Bytes()
+
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch r.URL.Path {
+		case "/t/p/w500/poster.png":
+			_, _ = w.Write(poster)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewImageCache(t.TempDir(), 0)
+	require.NoError(t, err)
+	h := ImageHandler(c, ImageOptions{BaseURL: s.URL, Resize: true}, discardLogger)
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/t/p/w500/poster.png", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, poster, w.Body.Bytes())
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// served from cache
+	w = get("/t/p/w500/poster.png", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(1), calls.Load())
+
+	w = get("/t/p/w500/poster.png", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = get("/t/p/w500/poster.png", http.Header{"Range": {"bytes=0-9"}})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, poster[:10], w.Body.Bytes())
+
+	// w400 isn't offered by TMDB: it's resized from w500
+	w = get("/t/p/w400/poster.png", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	resized, err := png.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 400, 600), resized.Bounds())
+	assert.Equal(t, int32(2), calls.Load())
+
+	w = get("/t/p/w500/missing.png", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = get("/t/p/w500/", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// invalid sizes are rejected without calling TMDB
+	for _, size := range []string{"foo", "w", "w0", "w-1", "w0500", "w5000", "h500"} {
+		w = get("/t/p/"+size+"/poster.png", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, size)
+	}
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestImageHandler_Size(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   string
+		resize bool
+		want   bool
+	}{
+		{name: "tmdb width", size: "w92", want: true},
+		{name: "original", size: "original", want: true},
+		{name: "other width", size: "w400", want: false},
+		{name: "resized width", size: "w400", resize: true, want: true},
+		{name: "too wide", size: "w2001", resize: true, want: false},
+		{name: "leading zero", size: "w092", resize: true, want: false},
+		{name: "no width", size: "original.jpg", resize: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := imageHandler{options: ImageOptions{Resize: tt.resize}}
+			assert.Equal(t, tt.want, h.validSize(tt.size))
+		})
+	}
+}
+
+func TestImageHandler_Limiter(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte("image"))
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewImageCache(t.TempDir(), 0)
+	require.NoError(t, err)
+	metrics := NewClientMetrics("", "", nil)
+	h := ImageHandler(c, ImageOptions{
+		BaseURL:       s.URL,
+		Limiter:       NewClientLimiter(Limits{Requests: 1, RequestsBurst: 2, Upstream: 1, UpstreamBurst: 1}),
+		ClientMetrics: metrics,
+	}, discardLogger)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, get("/t/p/w92/a.jpg").Code)
+	// the upstream limit only applies to images that aren't cached
+	w := get("/t/p/w92/b.jpg")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, int32(1), calls.Load())
+	// the request limit applies to all requests
+	assert.Equal(t, http.StatusTooManyRequests, get("/t/p/w92/a.jpg").Code)
+
+	assert.NoError(t, testutil.CollectAndCompare(metrics, strings.NewReader(`
+# HELP client_rate_limited_total Number of requests rejected by client and limit (requests, upstream)
+# TYPE client_rate_limited_total counter
+client_rate_limited_total{client="anonymous",limit="requests"} 1
+client_rate_limited_total{client="anonymous",limit="upstream"} 1
+`), "client_rate_limited_total"))
+}
+
+func TestImageHandler_Offline(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte("image"))
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewImageCache(t.TempDir(), 0)
+	require.NoError(t, err)
+	require.NoError(t, c.Store("/t/p/w92/a.jpg", []byte("image")))
+	h := ImageHandler(c, ImageOptions{BaseURL: s.URL, Offline: true}, discardLogger)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/t/p/w92/a.jpg", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/t/p/w92/b.jpg", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Zero(t, calls.Load())
+}
+
+func TestImageHandler_TooLarge(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte("large image"))
+	}))
+	t.Cleanup(s.Close)
+
+	c, err := NewImageCache(t.TempDir(), 4)
+	require.NoError(t, err)
+	h := ImageHandler(c, ImageOptions{BaseURL: s.URL}, discardLogger)
+
+	// images that don't fit in the cache are still served
+	for range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/t/p/w92/a.jpg", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "large image", w.Body.String())
+		assert.NotEmpty(t, w.Header().Get("ETag"))
+	}
+	assert.Equal(t, int32(2), calls.Load())
+	count, _ := c.Len()
+	assert.Zero(t, count)
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"cmp"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImageCache stores images on disk. When the total size of the images exceeds MaxSize bytes, the least recently used
+// images are removed. A zero MaxSize means no limit.
+//
+// Images never change on TMDB (a new image gets a new path), so cached images never expire.
+type ImageCache struct {
+	Directory string
+	MaxSize   int64
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+type imageCacheEntry struct {
+	name string
+	size int64
+}
+
+// NewImageCache returns an ImageCache that stores images in directory. Images already in the directory are
+// added to the cache, with the most recently used image first.
+func NewImageCache(directory string, maxSize int64) (*ImageCache, error) {
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return nil, err
+	}
+	c := ImageCache{
+		Directory: directory,
+		MaxSize:   maxSize,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".tmp-") {
+			_ = os.Remove(path)
+			return nil
+		}
+		info, err := entry.Info()
+		if err == nil {
+			files = append(files, file{name: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	for _, f := range files {
+		c.add(f.name, f.size)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.evict()
+	return &c, nil
+}
+
+// Open returns the cached image for key, or ErrNotFound if the image is not in the cache.
+func (c *ImageCache) Open(key string) (*os.File, error) {
+	name := imageCacheName(key)
+	c.lock.Lock()
+	elem, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.lock.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	path := c.path(name)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.remove(name)
+		return nil, ErrNotFound
+	}
+	// record the access, so the order of the LRU survives a restart
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return f, err
+}
+
+// ErrImageTooLarge is returned by ImageCache.Store if the image is larger than the cache's MaxSize.
+var ErrImageTooLarge = errors.New("image larger than cache")
+
+// Store adds the image to the cache, removing the least recently used images if the cache is full. Images larger than
+// MaxSize aren't stored: Store returns ErrImageTooLarge.
+func (c *ImageCache) Store(key string, image []byte) error {
+	if c.MaxSize > 0 && int64(len(image)) > c.MaxSize {
+		return ErrImageTooLarge
+	}
+	name := imageCacheName(key)
+	path := c.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(image)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	c.add(name, int64(len(image)))
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.evict()
+	return nil
+}
+
+// Len returns the number of images in the cache, and their total size.
+func (c *ImageCache) Len() (int, int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len(), c.size
+}
+
+func (c *ImageCache) add(name string, size int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[name]; ok {
+		c.size -= elem.Value.(*imageCacheEntry).size
+		c.lru.Remove(elem)
+	}
+	c.entries[name] = c.lru.PushFront(&imageCacheEntry{name: name, size: size})
+	c.size += size
+}
+
+func (c *ImageCache) remove(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[name]; ok {
+		c.size -= c.lru.Remove(elem).(*imageCacheEntry).size
+		delete(c.entries, name)
+	}
+}
+
+// evict removes the least recently used images until the cache fits MaxSize. The lock must be held.
+func (c *ImageCache) evict() {
+	for c.MaxSize > 0 && c.size > c.MaxSize && c.lru.Len() > 0 {
+		e := c.lru.Remove(c.lru.Back()).(*imageCacheEntry)
+		delete(c.entries, e.name)
+		c.size -= e.size
+		_ = os.Remove(c.path(e.name))
+	}
+}
+
+func (c *ImageCache) path(name string) string {
+	return filepath.Join(c.Directory, name[:2], name)
+}
+
+// imageCacheName returns the file name of the cached image. It keeps the extension of the image, so the image's
+// content type can be derived from the file name.
+func imageCacheName(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:]) + cmp.Or(strings.ToLower(filepath.Ext(key)), ".img")
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits sets the rate (per second) and burst size of a client's requests. A zero rate means no limit.
+type Limits struct {
+	// Requests limits all requests made by a client.
+	Requests      rate.Limit
+	RequestsBurst int
+	// Upstream limits the requests that a client causes the proxy to make to TMDB, i.e. cache misses.
+	Upstream      rate.Limit
+	UpstreamBurst int
+}
+
+// ClientLimiter limits the requests per client. Clients are identified by their name, as authenticated by
+// Authenticate, or else by their IP address.
+type ClientLimiter struct {
+	Limits Limits
+
+	lock      sync.Mutex
+	clients   map[string]*clientLimiters
+	lastPrune time.Time
+}
+
+func NewClientLimiter(limits Limits) *ClientLimiter {
+	return &ClientLimiter{Limits: limits, clients: make(map[string]*clientLimiters)}
+}
+
+type clientLimiters struct {
+	requests *rate.Limiter
+	upstream *rate.Limiter
+	lastSeen time.Time
+}
+
+// idleTimeout is how long a client's limiters are kept after its last request.
+const idleTimeout = 10 * time.Minute
+
+func (l *ClientLimiter) get(client string) *clientLimiters {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastPrune) > time.Minute {
+		for name, c := range l.clients {
+			if now.Sub(c.lastSeen) > idleTimeout {
+				delete(l.clients, name)
+			}
+		}
+		l.lastPrune = now
+	}
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiters{
+			requests: newLimiter(l.Limits.Requests, l.Limits.RequestsBurst),
+			upstream: newLimiter(l.Limits.Upstream, l.Limits.UpstreamBurst),
+		}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+	return c
+}
+
+func newLimiter(limit rate.Limit, burst int) *rate.Limiter {
+	if limit == 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(limit, max(1, burst))
+}
+
+// allowRequest reports whether the client may make another request. If not, it returns how long the client should
+// wait before trying again.
+func (l *ClientLimiter) allowRequest(client string) (bool, time.Duration) {
+	return allow(l.get(client).requests)
+}
+
+// allowUpstream reports whether the client may cause another call to TMDB. If not, it returns how long the client
+// should wait before trying again.
+func (l *ClientLimiter) allowUpstream(client string) (bool, time.Duration) {
+	return allow(l.get(client).upstream)
+}
+
+func allow(limiter *rate.Limiter) (bool, time.Duration) {
+	r := limiter.Reserve()
+	if !r.OK() {
+		return false, time.Second
+	}
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// clientName returns the name of the client that made the request: the name of its API key, or its IP address.
+func clientName(r *http.Request) string {
+	if name, ok := ClientName(r.Context()); ok {
+		return name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+var _ prometheus.Collector = &ClientMetrics{}
+
+// ClientMetrics records the requests of each client. Clients are identified by the name of their API key. Requests
+// that weren't authenticated are recorded as "anonymous", so the number of series doesn't grow with the number of
+// clients' IP addresses.
+type ClientMetrics struct {
+	requests *prometheus.CounterVec
+	limited  *prometheus.CounterVec
+}
+
+func NewClientMetrics(namespace, subsystem string, constLabels prometheus.Labels) *ClientMetrics {
+	return &ClientMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "client_requests_total",
+			Help:        "Number of requests by client and cache result (hit, negative_hit, miss)",
+			ConstLabels: constLabels,
+		}, []string{"client", "cache"}),
+		limited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "client_rate_limited_total",
+			Help:        "Number of requests rejected by client and limit (requests, upstream)",
+			ConstLabels: constLabels,
+		}, []string{"client", "limit"}),
+	}
+}
+
+func (m *ClientMetrics) measure(r *http.Request, result string) {
+	if m != nil {
+		m.requests.WithLabelValues(clientLabel(r), result).Inc()
+	}
+}
+
+func (m *ClientMetrics) rateLimited(r *http.Request, limit string) {
+	if m != nil {
+		m.limited.WithLabelValues(clientLabel(r), limit).Inc()
+	}
+}
+
+// clientLabel returns the client label of a request: the name of its API key, or "anonymous".
+func clientLabel(r *http.Request) string {
+	if name, ok := ClientName(r.Context()); ok {
+		return name
+	}
+	return "anonymous"
+}
+
+func (m *ClientMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.limited.Describe(ch)
+}
+
+func (m *ClientMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.limited.Collect(ch)
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientLimiter(t *testing.T) {
+	l := NewClientLimiter(Limits{Requests: rate.Every(time.Minute), RequestsBurst: 2})
+
+	for range 2 {
+		ok, _ := l.allowRequest("foo")
+		assert.True(t, ok)
+	}
+	ok, retryAfter := l.allowRequest("foo")
+	assert.False(t, ok)
+	assert.Greater(t, retryAfter, 50*time.Second)
+
+	// clients have their own limits
+	ok, _ = l.allowRequest("bar")
+	assert.True(t, ok)
+
+	// no upstream limit
+	for range 10 {
+		ok, _ = l.allowUpstream("foo")
+		assert.True(t, ok)
+	}
+}
+
+func TestClientName(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, "10.0.0.1", clientName(r))
+
+	assert.Equal(t, "anonymous", clientLabel(r))
+
+	r = r.WithContext(context.WithValue(r.Context(), clientNameKey{}, "degrees"))
+	assert.Equal(t, "degrees", clientName(r))
+	assert.Equal(t, "degrees", clientLabel(r))
+}
+
+func TestTMDBProxyHandler_RateLimit(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+
+	metrics := NewClientMetrics("", "", nil)
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{
+		Target:        s.URL,
+		TTL:           time.Hour,
+		Limiter:       NewClientLimiter(Limits{Requests: rate.Every(time.Minute), RequestsBurst: 4, Upstream: rate.Every(time.Minute), UpstreamBurst: 1}),
+		ClientMetrics: metrics,
+	}, discardLogger)
+
+	tests := []struct {
+		path           string
+		wantStatusCode int
+	}{
+		{path: "/3/movie/1", wantStatusCode: http.StatusOK},
+		{path: "/3/movie/1", wantStatusCode: http.StatusOK},
+		{path: "/3/movie/2", wantStatusCode: http.StatusTooManyRequests},
+		{path: "/3/movie/1", wantStatusCode: http.StatusOK},
+		{path: "/3/movie/1", wantStatusCode: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		h.ServeHTTP(w, r)
+		assert.Equal(t, tt.wantStatusCode, w.Code, tt.path)
+		if w.Code == http.StatusTooManyRequests {
+			assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		}
+	}
+
+	assert.NoError(t, testutil.CollectAndCompare(metrics, strings.NewReader(`
+# HELP client_rate_limited_total Number of requests rejected by client and limit (requests, upstream)
+# TYPE client_rate_limited_total counter
+client_rate_limited_total{client="anonymous",limit="requests"} 1
+client_rate_limited_total{client="anonymous",limit="upstream"} 1
+# HELP client_requests_total Number of requests by client and cache result (hit, negative_hit, miss)
+# TYPE client_requests_total counter
+client_requests_total{cache="hit",client="anonymous"} 2
+client_requests_total{cache="miss",client="anonymous"} 1
+`)))
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// A Locker provides a lock that is shared by all replicas.
+type Locker interface {
+	// TryLock attempts to acquire the lock for key, without waiting. If the lock is acquired, it is held until
+	// unlock is called, or until ttl expires.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(), ok bool, err error)
+}
+
+type RedisLockClient interface {
+	SetNX(ctx context.Context, key string, value any, expiration time.Duration) *redis.BoolCmd
+	Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd
+}
+
+var _ Locker = &RedisLocker{}
+
+// RedisLocker implements Locker using Redis.
+type RedisLocker struct {
+	Client RedisLockClient
+}
+
+func NewRedisLocker(client RedisLockClient) *RedisLocker {
+	return &RedisLocker{Client: client}
+}
+
+// unlockScript only removes the lock if it is still held by the caller, i.e. the lock hasn't expired and been
+// acquired by another replica.
+const unlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+func (r *RedisLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	token := hex.EncodeToString(id[:])
+	key += "|lock"
+
+	ok, err := r.Client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func() {
+		_ = r.Client.Eval(context.WithoutCancel(ctx), unlockScript, []string{key}, token).Err()
+	}, true, nil
+}
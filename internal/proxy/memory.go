@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ AdminBackend = &MemoryCache{}
+
+// MemoryCache stores cached responses in memory. When the cache holds more than MaxEntries entries, or their total size
+// exceeds MaxSize bytes, the least recently used entries are evicted. A zero limit means no limit.
+type MemoryCache struct {
+	MaxEntries int
+	MaxSize    int64
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+type memoryEntry struct {
+	key    string
+	value  []byte
+	expiry time.Time
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+func NewMemoryCache(maxEntries int, maxSize int64) *MemoryCache {
+	return &MemoryCache{
+		MaxEntries: maxEntries,
+		MaxSize:    maxSize,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (m *MemoryCache) Get(_ context.Context, key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	e := elem.Value.(*memoryEntry)
+	if !e.expiry.IsZero() && time.Now().After(e.expiry) {
+		m.remove(elem)
+		return nil, ErrNotFound
+	}
+	m.lru.MoveToFront(elem)
+	return e.value, nil
+}
+
+// TTL returns the time until the key expires, or 0 if it never expires.
+func (m *MemoryCache) TTL(_ context.Context, key string) (time.Duration, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	elem, ok := m.entries[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	e := elem.Value.(*memoryEntry)
+	if e.expiry.IsZero() {
+		return 0, nil
+	}
+	remaining := time.Until(e.expiry)
+	if remaining <= 0 {
+		return 0, ErrNotFound
+	}
+	return remaining, nil
+}
+
+func (m *MemoryCache) Set(_ context.Context, key string, value []byte, expiration time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	e := memoryEntry{key: key, value: value}
+	if expiration > 0 {
+		e.expiry = time.Now().Add(expiration)
+	}
+	if m.MaxSize > 0 && e.size() > m.MaxSize {
+		return nil
+	}
+	m.entries[key] = m.lru.PushFront(&e)
+	m.size += e.size()
+	for (m.MaxEntries > 0 && m.lru.Len() > m.MaxEntries) || (m.MaxSize > 0 && m.size > m.MaxSize) {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+// Delete removes the key from the cache.
+func (m *MemoryCache) Delete(_ context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	return nil
+}
+
+func (m *MemoryCache) Scan(ctx context.Context, prefix string, f func(key string) error) error {
+	// collect the keys first, so f can modify the cache
+	m.lock.Lock()
+	now := time.Now()
+	var keys []string
+	for key, elem := range m.entries {
+		if e := elem.Value.(*memoryEntry); strings.HasPrefix(key, prefix) && (e.expiry.IsZero() || now.Before(e.expiry)) {
+			keys = append(keys, key)
+		}
+	}
+	m.lock.Unlock()
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryCache) Stats(_ context.Context) (BackendStats, error) {
+	count, size := m.Len()
+	return BackendStats{Keys: count, Bytes: size}, nil
+}
+
+func (m *MemoryCache) Ping(_ context.Context) error {
+	return nil
+}
+
+// Len returns the number of entries in the cache, and their total size.
+func (m *MemoryCache) Len() (int, int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.lru.Len(), m.size
+}
+
+func (m *MemoryCache) remove(elem *list.Element) {
+	e := m.lru.Remove(elem).(*memoryEntry)
+	delete(m.entries, e.key)
+	m.size -= e.size()
+}
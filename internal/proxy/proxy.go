@@ -1,89 +1,499 @@
 package proxy
 
 import (
+	"bytes"
 	"cmp"
+	"context"
+	"encoding/json"
 	"errors"
 	"github.com/clambin/go-common/httputils/roundtripper"
-	"github.com/redis/go-redis/v9"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbrecord"
+	"golang.org/x/sync/singleflight"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-func TMDBProxyHandler(target string, redisClient RedisClient, ttl time.Duration, cacheMetrics roundtripper.CacheMetrics, logger *slog.Logger) http.Handler {
-	tp := http.DefaultTransport.(*http.Transport).Clone()
-	tp.MaxIdleConns = 100
-	tp.MaxIdleConnsPerHost = 100
-	tp.MaxConnsPerHost = 100
-
-	client := tmdbClient{
-		TargetHost: cmp.Or(target, "https://api.themoviedb.org"),
-		httpClient: &http.Client{
-			Transport: tp,
-			Timeout:   time.Second * 10,
+type Options struct {
+	// Target is the TMDB API server. Defaults to https://api.themoviedb.org.
+	Target string
+	// Token, if set, is the TMDB API read access token used for all calls to TMDB. Any credentials sent by the
+	// client are replaced.
+	Token string
+	// TTL is how long a cached response is fresh, unless a TTLRule applies.
+	TTL time.Duration
+	// TTLRules set the TTL for specific paths. The first matching rule applies.
+	TTLRules []TTLRule
+	// HonorMaxAge uses the max-age of TMDB's Cache-Control header, if present, as the TTL.
+	HonorMaxAge bool
+	// Vary lists the request headers whose values select a different cached response, e.g. Accept-Language.
+	Vary []string
+	// DebugHeaders adds the X-Cache-Key header, holding the request's cache key, to all responses.
+	DebugHeaders bool
+	// NegativeTTL is how long responses with one of the NegativeStatusCodes are cached (0: not cached).
+	NegativeTTL time.Duration
+	// NegativeStatusCodes are the status codes of negative responses. Defaults to 404. 401, 429 and 5xx responses are
+	// never cached.
+	NegativeStatusCodes []int
+	// StaleWhileRevalidate is how long after a response becomes stale it may still be served, while it's refreshed
+	// in the background.
+	StaleWhileRevalidate time.Duration
+	// StaleIfError is how long after a response becomes stale it may still be served, if TMDB can't be reached.
+	StaleIfError time.Duration
+	// CacheMetrics, if set, records cache hits and misses.
+	CacheMetrics roundtripper.CacheMetrics
+	// NegativeCacheMetrics, if set, records the cache hits that served a negative response.
+	NegativeCacheMetrics *NegativeCacheMetrics
+	// Locker, if set, ensures only one replica calls TMDB for the same request. Other replicas wait up to LockTimeout
+	// for the response to appear in the cache, before calling TMDB themselves.
+	Locker      Locker
+	LockTimeout time.Duration
+	// Upstream limits the calls to TMDB and backs off when TMDB throttles the proxy. If nil, calls aren't limited,
+	// but the proxy still backs off when throttled.
+	Upstream *UpstreamLimiter
+	// Breaker, if set, stops calling TMDB while too many calls fail or are slow. Requests are then served from stale
+	// cached responses (within StaleIfError), or fail with 503 Service Unavailable.
+	Breaker *CircuitBreaker
+	// Limiter, if set, limits the requests per client. Cache hits only count towards the client's request limit;
+	// cache misses also count towards its upstream limit.
+	Limiter *ClientLimiter
+	// ClientMetrics, if set, records the requests per client.
+	ClientMetrics *ClientMetrics
+	// Transport, if set, makes the calls to TMDB, e.g. a tmdbrecord.Recorder or tmdbrecord.Replayer.
+	Transport http.RoundTripper
+	// Offline never calls TMDB: cached responses are served, even if stale, and cache misses return 504 Gateway Timeout.
+	Offline bool
+}
+
+func TMDBProxyHandler(backend Backend, options Options, logger *slog.Logger) http.Handler {
+	transport := options.Transport
+	if transport == nil {
+		tp := http.DefaultTransport.(*http.Transport).Clone()
+		tp.MaxIdleConns = 100
+		tp.MaxIdleConnsPerHost = 100
+		tp.MaxConnsPerHost = 100
+		transport = tp
+	}
+
+	return &proxyHandler{
+		options: options,
+		client: tmdbClient{
+			TargetHost: cmp.Or(options.Target, "https://api.themoviedb.org"),
+			Token:      options.Token,
+			upstream:   cmp.Or(options.Upstream, NewUpstreamLimiter(0, 0, 0, "", "", nil)),
+			breaker:    options.Breaker,
+			httpClient: &http.Client{
+				Transport: transport,
+				Timeout:   time.Second * 10,
+			},
+		},
+		policy: cachePolicy{
+			defaultTTL:  options.TTL,
+			rules:       options.TTLRules,
+			honorMaxAge: options.HonorMaxAge,
+		},
+		responses: responseCache{
+			Namespace: cacheNamespace,
+			Vary:      options.Vary,
+			Backend:   backend,
 		},
+		logger: logger,
 	}
+}
+
+// cacheNamespace prefixes all cache keys, so the proxy can share a cache (e.g. a Redis database) with other applications.
+const cacheNamespace = "github.com/clambin/tmdb"
+
+type proxyHandler struct {
+	options    Options
+	client     tmdbClient
+	policy     cachePolicy
+	responses  responseCache
+	logger     *slog.Logger
+	refreshing sync.Map
+	inflight   singleflight.Group
+}
 
-	responses := responseCache{
-		Namespace: "github.com/clambin/tmdb",
-		Client:    redisClient,
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// HEAD requests get the headers of the GET response, which may be cached
+	head := r.Method == http.MethodHead
+	if head {
+		r = r.Clone(r.Context())
+		r.Method = http.MethodGet
 	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var fetched bool
-		resp, err := responses.Get(r.Context(), r)
-		if err != nil {
-			if !errors.Is(err, redis.Nil) {
-				logger.Warn("failed to get cached response", "err", err)
+	client := clientName(r)
+	if h.options.Limiter != nil {
+		if ok, retryAfter := h.options.Limiter.allowRequest(client); !ok {
+			h.logger.Debug("request rate limit exceeded", "client", client)
+			h.options.ClientMetrics.rateLimited(r, "requests")
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+	}
+
+	entry, err := h.responses.Get(r.Context(), r)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		h.logger.Warn("failed to get cached response", "err", err)
+	}
+	cached := err == nil
+
+	var warning string
+	served := entry
+	status := "HIT"
+	switch {
+	case cached && entry.fresh():
+	case cached && h.options.Offline:
+		warning = `111 - "Revalidation Failed"`
+		status = "STALE"
+	case h.options.Offline:
+		h.logger.Debug("cache miss while offline")
+		if h.options.CacheMetrics != nil {
+			h.options.CacheMetrics.Measure(r, false)
+		}
+		h.options.ClientMetrics.measure(r, "miss")
+		http.Error(w, "not in cache and proxy is offline", http.StatusGatewayTimeout)
+		return
+	case cached && entry.staleFor() < h.options.StaleWhileRevalidate:
+		h.logger.Debug("serving stale response", "age", entry.age())
+		if h.options.Limiter == nil {
+			h.refresh(r, entry)
+		} else if ok, _ := h.options.Limiter.allowUpstream(client); ok {
+			h.refresh(r, entry)
+		}
+		warning = `110 - "Response is Stale"`
+		status = "STALE"
+	default:
+		h.logger.Debug("cache miss")
+		if h.options.Limiter != nil {
+			if ok, retryAfter := h.options.Limiter.allowUpstream(client); !ok {
+				h.logger.Debug("upstream rate limit exceeded", "client", client)
+				h.options.ClientMetrics.rateLimited(r, "upstream")
+				writeTooManyRequests(w, retryAfter)
+				return
+			}
+		}
+		cached = false
+		status = "MISS"
+		served, err = h.fetch(r, entry)
+		if err != nil || served.statusCode >= http.StatusInternalServerError {
+			if entry.header != nil && entry.staleFor() < h.options.StaleIfError {
+				h.logger.Warn("tmdb call failed. serving stale response", "err", err, "age", entry.age())
+				cached = true
+				served, err = entry, nil
+				warning = `111 - "Revalidation Failed"`
+				status = "STALE"
 			}
+		}
+	}
+
+	if retryAfter, ok := isThrottled(err); ok {
+		h.logger.Warn("tmdb rate limit reached", "retryAfter", retryAfter)
+		writeTooManyRequests(w, retryAfter)
+		return
+	}
+	var openErr *CircuitOpenError
+	if errors.As(err, &openErr) {
+		h.logger.Debug("tmdb circuit breaker open", "retryAfter", openErr.RetryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(openErr.RetryAfter.Seconds()))))
+		http.Error(w, "tmdb unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, tmdbrecord.ErrNotRecorded) {
+		// replaying recorded calls: like a cache miss while offline, this isn't an upstream failure
+		h.logger.Debug("tmdb call not recorded", "err", err)
+		http.Error(w, "not recorded and proxy is replaying", http.StatusGatewayTimeout)
+		return
+	}
+	var body []byte
+	var encoding string
+	if err == nil {
+		body, encoding, err = served.encodedBody(r)
+	}
+	if err != nil {
+		h.logger.Warn("failed to process request", "err", err)
+		http.Error(w, "failed to process request", http.StatusBadGateway)
+		return
+	}
+
+	if h.options.CacheMetrics != nil {
+		h.options.CacheMetrics.Measure(r, cached)
+	}
+	result := "miss"
+	if cached {
+		result = "hit"
+		if served.statusCode != http.StatusOK {
+			result = "negative_hit"
+			h.options.NegativeCacheMetrics.measure(r, served.statusCode)
+		}
+	}
+	h.options.ClientMetrics.measure(r, result)
 
-			logger.Debug("cache miss")
+	copyHeader(w.Header(), served.header)
+	// the protocol version of TMDB's response isn't cached: assume HTTP/1.1
+	w.Header().Add("Via", via(1, 1))
+	w.Header().Set("X-Cache", status)
+	if warning != "" {
+		w.Header().Set("Warning", warning)
+	}
+	if h.options.DebugHeaders {
+		w.Header().Set("X-Cache-Key", h.responses.getKey(r))
+	}
+	// the request headers that select the cached response must be named in Vary, so downstream caches don't serve
+	// one client's variant to another
+	vary := h.options.Vary
+	if served.gzipped {
+		vary = append(slices.Clone(vary), "Accept-Encoding")
+	}
+	addVary(w.Header(), vary)
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if served.statusCode == http.StatusOK || h.negative(served.statusCode) {
+		w.Header().Set("Age", strconv.Itoa(int(served.age().Seconds())))
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(served.ttl().Seconds())))
+	}
+	if served.statusCode == http.StatusOK {
+		if notModified(r, w.Header()) {
+			w.Header().Del("Content-Length")
+			w.Header().Del("Content-Encoding")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(served.statusCode)
+	if !head {
+		_, _ = w.Write(body)
+	}
+}
 
-			resp, err = client.call(r)
-			logger.Debug("tmdb called", "err", err)
+// fetch calls TMDB and caches the response if it was successful. Concurrent fetches of the same request are
+// coalesced into a single call to TMDB.
+//
+// If a stale copy of the response is available, TMDB is asked to only return the response if it has changed.
+func (h *proxyHandler) fetch(r *http.Request, stale cachedResponse) (cachedResponse, error) {
+	key := h.responses.getKey(r)
+	entry, err, shared := h.inflight.Do(key, func() (any, error) {
+		// the result is shared by all callers: don't let one caller canceling its request fail the others
+		r := r.Clone(context.WithoutCancel(r.Context()))
+		if h.options.Locker != nil {
+			unlock, entry, err := h.lock(r, key)
+			if err != nil || entry.header != nil {
+				return entry, err
+			}
+			defer unlock()
+		}
+		return h.callTMDB(r, stale)
+	})
+	h.logger.Debug("tmdb called", "err", err, "shared", shared)
+	return entry.(cachedResponse), err
+}
 
-			if err == nil && resp.StatusCode == http.StatusOK {
-				fetched = true
-				err = responses.Set(r.Context(), r, resp, ttl)
+func (h *proxyHandler) callTMDB(r *http.Request, stale cachedResponse) (cachedResponse, error) {
+	resp, err := h.client.call(r, stale.validators())
+	if err != nil {
+		return cachedResponse{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	ttl, rule, cacheable := h.policy.ttl(r, resp)
+	expiration := ttl + max(h.options.StaleWhileRevalidate, h.options.StaleIfError)
 
-				logger.Debug("stored in cache", "err", err)
+	var entry cachedResponse
+	switch {
+	case resp.StatusCode == http.StatusNotModified && stale.header != nil:
+		// our stale copy is still valid: renew it
+		h.logger.Debug("stale response revalidated")
+		entry = stale.renew(ttl)
+	default:
+		switch {
+		case resp.StatusCode == http.StatusOK && cacheable:
+			if err = addValidators(resp); err != nil {
+				return cachedResponse{}, err
 			}
+		case h.negative(resp.StatusCode) && cacheable:
+			// negative responses are never served stale
+			ttl, rule, expiration = h.options.NegativeTTL, "negative", h.options.NegativeTTL
+		default:
+			cacheable = false
+		}
+		if cacheable {
+			resp.Header.Set("X-Cache-Policy", "rule="+rule+"; ttl="+strconv.Itoa(int(ttl.Seconds())))
 		}
+		if entry, err = newCachedResponse(resp, ttl); err != nil {
+			return entry, err
+		}
+	}
+	if !cacheable {
+		return entry, nil
+	}
+	err = h.responses.Set(r.Context(), r, entry, expiration)
+	h.logger.Debug("stored in cache", "err", err, "ttl", ttl, "rule", rule)
+	return entry, err
+}
 
+// negative reports whether a response with the status code is cached as a negative response. Authentication errors,
+// rate limiting and server errors are never cached.
+func (h *proxyHandler) negative(statusCode int) bool {
+	if h.options.NegativeTTL <= 0 || statusCode == http.StatusUnauthorized || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError {
+		return false
+	}
+	statusCodes := h.options.NegativeStatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = []int{http.StatusNotFound}
+	}
+	return slices.Contains(statusCodes, statusCode)
+}
+
+// lock acquires the distributed lock for the request. If another replica holds the lock, lock waits for that replica
+// to store the response in the cache and returns the cached response. If the lock can't be acquired in time, lock
+// returns without holding the lock, and the caller calls TMDB itself.
+func (h *proxyHandler) lock(r *http.Request, key string) (func(), cachedResponse, error) {
+	timeout := cmp.Or(h.options.LockTimeout, 10*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	const pollInterval = 50 * time.Millisecond
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		unlock, ok, err := h.options.Locker.TryLock(ctx, key, timeout)
 		if err != nil {
-			logger.Warn("failed to process request", "err", err)
-			http.Error(w, "failed to process request", http.StatusBadGateway)
-			return
+			h.logger.Warn("failed to acquire lock", "err", err)
+			return func() {}, cachedResponse{}, nil
 		}
-
-		if cacheMetrics != nil {
-			cacheMetrics.Measure(r, !fetched)
+		// the replica that held the lock before us may already have stored the response
+		if entry, err := h.responses.Get(ctx, r); err == nil && entry.fresh() {
+			if ok {
+				unlock()
+			}
+			return func() {}, entry, nil
 		}
+		if ok {
+			return unlock, cachedResponse{}, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err = r.Context().Err(); err != nil {
+				return func() {}, cachedResponse{}, err
+			}
+			h.logger.Warn("timed out waiting for lock")
+			return func() {}, cachedResponse{}, nil
+		}
+	}
+}
 
-		w.WriteHeader(resp.StatusCode)
-		copyHeader(w.Header(), resp.Header)
-		_, _ = io.Copy(w, resp.Body)
-		_ = resp.Body.Close()
-	})
+// refresh fetches a new copy of a stale response in the background. Only one refresh per request runs at a time.
+func (h *proxyHandler) refresh(r *http.Request, stale cachedResponse) {
+	key := h.responses.getKey(r)
+	if _, running := h.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Minute)
+	req := r.Clone(ctx)
+	go func() {
+		defer h.refreshing.Delete(key)
+		defer cancel()
+		_, err := h.fetch(req, stale)
+		h.logger.Debug("stale response refreshed", "err", err)
+	}()
 }
 
 type tmdbClient struct {
 	TargetHost string
+	Token      string
+	upstream   *UpstreamLimiter
+	breaker    *CircuitBreaker
 	httpClient *http.Client
 }
 
-func (p tmdbClient) call(r *http.Request) (*http.Response, error) {
+// call forwards the request to TMDB. Conditional headers of the client are replaced by the validators of our own
+// cached copy, if any: the client's validators apply to the proxy's response, not to TMDB's.
+func (p tmdbClient) call(r *http.Request, validators http.Header) (*http.Response, error) {
+	rawQuery := r.URL.RawQuery
+	if query := r.URL.Query(); p.Token != "" && query.Has("api_key") {
+		query.Del("api_key")
+		rawQuery = query.Encode()
+	}
 	target := p.TargetHost + r.URL.Path
-	if r.URL.RawQuery != "" {
-		target += "?" + r.URL.RawQuery
+	if rawQuery != "" {
+		target += "?" + rawQuery
 	}
 	req, _ := http.NewRequestWithContext(r.Context(), r.Method, target, nil)
 	copyHeader(req.Header, r.Header)
-	// ask for non-compressed responses so we have a clear text copy in our cache
-	req.Header.Set("Accept-Encoding", "identity")
+	removeHopByHopHeaders(req.Header)
+	req.Header.Add("Via", via(r.ProtoMajor, r.ProtoMinor))
+	addForwardedFor(req.Header, r)
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	copyHeader(req.Header, validators)
+	// cached responses are stored gzip-compressed: ask TMDB for a compressed response, so we can store it as-is
+	req.Header.Set("Accept-Encoding", "gzip")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	done, err := p.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.upstream.wait(r.Context()); err != nil {
+		done(0, 0, err)
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := p.do(req)
+	var statusCode int
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+	done(statusCode, time.Since(start), err)
+	return resp, err
+}
+
+func (p tmdbClient) do(req *http.Request) (*http.Response, error) {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		return nil, &ThrottledError{RetryAfter: p.upstream.throttle(resp)}
+	}
+	// read the full body, so a slow or failing upstream surfaces as an error here
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
 
-	return p.httpClient.Do(req)
+// addVary adds the names to the Vary header, unless they are already listed.
+func addVary(header http.Header, names []string) {
+	for _, name := range names {
+		if !slices.ContainsFunc(header.Values("Vary"), func(value string) bool {
+			return slices.ContainsFunc(strings.Split(value, ","), func(listed string) bool {
+				return strings.EqualFold(strings.TrimSpace(listed), name)
+			})
+		}) {
+			header.Add("Vary", name)
+		}
+	}
 }
 
 func copyHeader(dst, src http.Header) {
@@ -94,13 +504,40 @@ func copyHeader(dst, src http.Header) {
 	}
 }
 
-func HealthHandler(client RedisClient, logger *slog.Logger) http.Handler {
+// HealthHandler reports the health of the cache backend, whether TMDB is throttling the proxy and the state of the
+// circuit breaker. Throttling and an open circuit breaker don't make the proxy unhealthy, as it still serves responses
+// from the cache.
+func HealthHandler(backend Backend, upstream *UpstreamLimiter, breaker *CircuitBreaker, logger *slog.Logger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var health struct {
+			Cache    string `json:"cache"`
+			Upstream struct {
+				Throttled         bool    `json:"throttled"`
+				RetryAfterSeconds float64 `json:"retry_after_seconds,omitempty"`
+				Circuit           string  `json:"circuit,omitempty"`
+				CircuitOpenFor    float64 `json:"circuit_open_seconds,omitempty"`
+			} `json:"upstream"`
+		}
 		statusCode := http.StatusOK
-		if err := client.Ping(r.Context()).Err(); err != nil {
+		health.Cache = "ok"
+		if err := backend.Ping(r.Context()); err != nil {
 			logger.Warn("failed to ping cache", "err", err)
 			statusCode = http.StatusServiceUnavailable
+			health.Cache = err.Error()
+		}
+		if upstream != nil {
+			if backoff := upstream.BackoffRemaining(); backoff > 0 {
+				health.Upstream.Throttled = true
+				health.Upstream.RetryAfterSeconds = math.Ceil(backoff.Seconds())
+			}
+		}
+		if breaker != nil {
+			var openFor time.Duration
+			health.Upstream.Circuit, openFor = breaker.State()
+			health.Upstream.CircuitOpenFor = math.Ceil(openFor.Seconds())
 		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(statusCode)
+		_ = json.NewEncoder(w).Encode(health)
 	})
 }
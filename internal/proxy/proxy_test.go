@@ -6,14 +6,21 @@ import (
 	"errors"
 	"github.com/clambin/go-common/cache"
 	"github.com/clambin/go-common/httputils/roundtripper"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbrecord"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbtest"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -61,8 +68,7 @@ func TestProxyHandler(t *testing.T) {
 			}))
 			t.Cleanup(s.Close)
 
-			redisClient := fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)}
-			h := TMDBProxyHandler(s.URL, &redisClient, time.Minute, nil, discardLogger)
+			h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Minute}, discardLogger)
 
 			var w httptest.ResponseRecorder
 			r, _ := http.NewRequest(http.MethodGet, "http://localhost"+tt.path, nil)
@@ -72,6 +78,45 @@ func TestProxyHandler(t *testing.T) {
 	}
 }
 
+func TestTMDBProxyHandler_Vary(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	t.Cleanup(s.Close)
+
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Hour, Vary: []string{"Accept-Language"}, DebugHeaders: true}, discardLogger)
+
+	tests := []struct {
+		target         string
+		acceptLanguage string
+		wantBody       string
+		wantKey        string
+	}{
+		{target: "/3/movie/550?page=1&language=en-US", acceptLanguage: "en", wantBody: "en", wantKey: "github.com/clambin/tmdb|GET|/3/movie/550?include_adult=false&language=en-US&page=1|accept-language=en"},
+		{target: "/3/movie/550?page=1", acceptLanguage: "en", wantBody: "en", wantKey: "github.com/clambin/tmdb|GET|/3/movie/550?include_adult=false&language=en-US&page=1|accept-language=en"},
+		{target: "/3/movie/550?page=1", acceptLanguage: "fr", wantBody: "fr", wantKey: "github.com/clambin/tmdb|GET|/3/movie/550?include_adult=false&language=en-US&page=1|accept-language=fr"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.Header.Set("Accept-Language", tt.acceptLanguage)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, tt.wantBody, w.Body.String())
+		assert.Equal(t, tt.wantKey, w.Header().Get("X-Cache-Key"))
+		// downstream caches must know that the response depends on Accept-Language
+		assert.Equal(t, []string{"Accept-Language", "Accept-Encoding"}, w.Result().Header.Values("Vary"))
+	}
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestAddVary(t *testing.T) {
+	header := http.Header{"Vary": {"accept-encoding, Origin"}}
+	addVary(header, []string{"Accept-Language", "Accept-Encoding"})
+	assert.Equal(t, []string{"accept-encoding, Origin", "Accept-Language"}, header.Values("Vary"))
+}
+
 func TestTMDBProxyHandler_Metrics(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -83,7 +128,7 @@ func TestTMDBProxyHandler_Metrics(t *testing.T) {
 		return "/"
 	}})
 
-	h := TMDBProxyHandler(s.URL, &fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)}, time.Minute, cacheMetrics, discardLogger)
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Minute, CacheMetrics: cacheMetrics}, discardLogger)
 	r, err := http.NewRequest(http.MethodGet, "/foo", nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, r)
@@ -114,7 +159,7 @@ func TestTMDBProxyHandler_Integrity(t *testing.T) {
 	}))
 	t.Cleanup(s.Close)
 
-	h := TMDBProxyHandler(s.URL, &fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)}, time.Minute, nil, discardLogger)
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Minute}, discardLogger)
 	r, err := http.NewRequest(http.MethodGet, "/foo", nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, r)
@@ -124,9 +169,150 @@ func TestTMDBProxyHandler_Integrity(t *testing.T) {
 	t.Log(w.Header())
 }
 
+func TestTMDBProxyHandler_Stale(t *testing.T) {
+	var version atomic.Int32
+	var failing atomic.Bool
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("v" + strconv.Itoa(int(version.Add(1)))))
+	}))
+	t.Cleanup(s.Close)
+
+	get := func(h http.Handler) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(http.MethodGet, "/foo", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("stale while revalidate", func(t *testing.T) {
+		version.Store(0)
+		failing.Store(false)
+		h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: 10 * time.Millisecond, StaleWhileRevalidate: time.Hour}, discardLogger)
+		w := get(h)
+		assert.Equal(t, "v1", w.Body.String())
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+
+		time.Sleep(20 * time.Millisecond)
+		w = get(h)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "v1", w.Body.String())
+		assert.Equal(t, `110 - "Response is Stale"`, w.Header().Get("Warning"))
+		assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+		assert.Equal(t, "0", w.Header().Get("Age"))
+		assert.Equal(t, "public, max-age=0", w.Header().Get("Cache-Control"))
+
+		assert.Eventually(t, func() bool { return get(h).Body.String() == "v2" }, time.Second, time.Millisecond)
+	})
+
+	t.Run("stale if error", func(t *testing.T) {
+		version.Store(0)
+		failing.Store(false)
+		h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: 10 * time.Millisecond, StaleIfError: time.Hour}, discardLogger)
+		assert.Equal(t, "v1", get(h).Body.String())
+
+		failing.Store(true)
+		time.Sleep(20 * time.Millisecond)
+		w := get(h)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "v1", w.Body.String())
+		assert.Equal(t, `111 - "Revalidation Failed"`, w.Header().Get("Warning"))
+
+		failing.Store(false)
+		w = get(h)
+		assert.Equal(t, "v2", w.Body.String())
+		assert.Empty(t, w.Header().Get("Warning"))
+	})
+
+	t.Run("too old", func(t *testing.T) {
+		version.Store(0)
+		failing.Store(false)
+		h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: 10 * time.Millisecond, StaleIfError: 10 * time.Millisecond}, discardLogger)
+		assert.Equal(t, "v1", get(h).Body.String())
+
+		failing.Store(true)
+		time.Sleep(30 * time.Millisecond)
+		assert.Equal(t, http.StatusServiceUnavailable, get(h).Code)
+	})
+
+	t.Run("upstream down", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello"))
+		}))
+		h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: 10 * time.Millisecond, StaleIfError: time.Hour}, discardLogger)
+		assert.Equal(t, "hello", get(h).Body.String())
+
+		s.Close()
+		time.Sleep(20 * time.Millisecond)
+		w := get(h)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "hello", w.Body.String())
+		assert.Equal(t, `111 - "Revalidation Failed"`, w.Header().Get("Warning"))
+	})
+}
+
+func TestTMDBProxyHandler_Coalescing(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(s.Close)
+
+	backend := NewMemoryCache(0, 0)
+	locker := NewRedisLocker(&fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)})
+	// two replicas, sharing the same cache
+	replicas := []http.Handler{
+		TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Hour, Locker: locker}, discardLogger),
+		TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Hour, Locker: locker}, discardLogger),
+	}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, _ := http.NewRequest(http.MethodGet, "/foo", nil)
+			w := httptest.NewRecorder()
+			replicas[i%len(replicas)].ServeHTTP(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "hello", w.Body.String())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRedisLocker(t *testing.T) {
+	l := NewRedisLocker(&fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)})
+	ctx := context.Background()
+
+	unlock, ok, err := l.TryLock(ctx, "foo", time.Hour)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = l.TryLock(ctx, "foo", time.Hour)
+	require.NoError(t, err)
+	require.False(t, ok)
+	unlock()
+	unlock2, ok, err := l.TryLock(ctx, "foo", time.Hour)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// unlocking an expired lock doesn't release the lock acquired by someone else
+	unlock()
+	_, ok, err = l.TryLock(ctx, "foo", time.Hour)
+	require.NoError(t, err)
+	require.False(t, ok)
+	unlock2()
+}
+
 func TestHealthHandler(t *testing.T) {
 	var redisClient fakeRedisClient
-	h := HealthHandler(&redisClient, discardLogger)
+	h := HealthHandler(NewRedisCache(&redisClient), nil, nil, discardLogger)
 
 	r, _ := http.NewRequest(http.MethodGet, "/healthz", nil)
 	w := httptest.NewRecorder()
@@ -145,21 +331,66 @@ var _ RedisClient = &fakeRedisClient{}
 
 type fakeRedisClient struct {
 	cache   *cache.Cache[string, string]
+	expiry  sync.Map
 	pingErr error
+	lock    sync.Mutex
 }
 
-func (f fakeRedisClient) Ping(ctx context.Context) *redis.StatusCmd {
+func (f *fakeRedisClient) Ping(ctx context.Context) *redis.StatusCmd {
 	cmd := redis.NewStatusCmd(ctx)
 	cmd.SetErr(f.pingErr)
 	return cmd
 }
 
-func (f fakeRedisClient) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) *redis.StatusCmd {
-	f.cache.AddWithExpiry(key, value.(string), ttl)
+func (f *fakeRedisClient) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) *redis.StatusCmd {
+	f.cache.AddWithExpiry(key, string(value.([]byte)), ttl)
+	if ttl > 0 {
+		f.expiry.Store(key, time.Now().Add(ttl))
+	} else {
+		f.expiry.Delete(key)
+	}
 	return redis.NewStatusCmd(ctx)
 }
 
-func (f fakeRedisClient) Get(ctx context.Context, key string) *redis.StringCmd {
+// PTTL returns the same values as Redis: -2 if the key doesn't exist, -1 if it has no expiry.
+func (f *fakeRedisClient) PTTL(ctx context.Context, key string) *redis.DurationCmd {
+	cmd := redis.NewDurationCmd(ctx, time.Millisecond)
+	if _, ok := f.cache.Get(key); !ok {
+		cmd.SetVal(-2)
+	} else if expiry, ok := f.expiry.Load(key); ok {
+		cmd.SetVal(max(0, time.Until(expiry.(time.Time)).Truncate(time.Millisecond)))
+	} else {
+		cmd.SetVal(-1)
+	}
+	return cmd
+}
+
+func (f *fakeRedisClient) SetNX(ctx context.Context, key string, value any, ttl time.Duration) *redis.BoolCmd {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	cmd := redis.NewBoolCmd(ctx)
+	if _, ok := f.cache.Get(key); !ok {
+		f.cache.AddWithExpiry(key, value.(string), ttl)
+		cmd.SetVal(true)
+	}
+	return cmd
+}
+
+// Eval only supports unlockScript
+func (f *fakeRedisClient) Eval(ctx context.Context, _ string, keys []string, args ...any) *redis.Cmd {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	cmd := redis.NewCmd(ctx)
+	if value, ok := f.cache.Get(keys[0]); ok && value == args[0].(string) {
+		f.cache.Remove(keys[0])
+		cmd.SetVal(int64(1))
+	} else {
+		cmd.SetVal(int64(0))
+	}
+	return cmd
+}
+
+func (f *fakeRedisClient) Get(ctx context.Context, key string) *redis.StringCmd {
 	value, ok := f.cache.Get(key)
 	cmd := redis.NewStringCmd(ctx)
 	if !ok {
@@ -169,3 +400,229 @@ func (f fakeRedisClient) Get(ctx context.Context, key string) *redis.StringCmd {
 	}
 	return cmd
 }
+
+func (f *fakeRedisClient) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx)
+	var deleted int64
+	for _, key := range keys {
+		if _, ok := f.cache.GetAndRemove(key); ok {
+			deleted++
+		}
+	}
+	cmd.SetVal(deleted)
+	return cmd
+}
+
+// Scan returns all matching keys in one page. Only supports "prefix*" patterns.
+func (f *fakeRedisClient) Scan(ctx context.Context, _ uint64, match string, _ int64) *redis.ScanCmd {
+	prefix := strings.NewReplacer(`\*`, `*`, `\?`, `?`, `\[`, `[`, `\]`, `]`, `\\`, `\`).Replace(strings.TrimSuffix(match, "*"))
+	var keys []string
+	for _, key := range f.cache.Keys() {
+		if _, ok := f.cache.Get(key); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	cmd := redis.NewScanCmd(ctx, nil)
+	cmd.SetVal(keys, 0)
+	return cmd
+}
+
+func (f *fakeRedisClient) Info(ctx context.Context, _ ...string) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx)
+	cmd.SetVal("# Memory\r\nused_memory:1024\r\nused_memory_human:1.00K\r\n")
+	return cmd
+}
+
+func TestTMDBProxyHandler_NegativeCaching(t *testing.T) {
+	var calls sync.Map
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, _ := calls.LoadOrStore(r.URL.Path, new(atomic.Int32))
+		count.(*atomic.Int32).Add(1)
+		statusCode, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		http.Error(w, http.StatusText(statusCode), statusCode)
+	}))
+	t.Cleanup(s.Close)
+
+	metrics := NewClientMetrics("", "", nil)
+	negativeMetrics := NewNegativeCacheMetrics("", "", nil)
+	backend := NewMemoryCache(0, 0)
+	h := TMDBProxyHandler(backend, Options{
+		Target:               s.URL,
+		TTL:                  time.Hour,
+		NegativeTTL:          time.Minute,
+		NegativeStatusCodes:  []int{http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusUnauthorized, http.StatusServiceUnavailable},
+		ClientMetrics:        metrics,
+		NegativeCacheMetrics: negativeMetrics,
+	}, discardLogger)
+
+	tests := []struct {
+		statusCode int
+		wantCalls  int32
+	}{
+		{statusCode: http.StatusNotFound, wantCalls: 1},
+		{statusCode: http.StatusUnprocessableEntity, wantCalls: 1},
+		{statusCode: http.StatusBadRequest, wantCalls: 2},
+		{statusCode: http.StatusUnauthorized, wantCalls: 2},
+		{statusCode: http.StatusServiceUnavailable, wantCalls: 2},
+		// TMDB throttling us makes the proxy back off: the second request doesn't reach TMDB
+		{statusCode: http.StatusTooManyRequests, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.statusCode), func(t *testing.T) {
+			path := "/" + strconv.Itoa(tt.statusCode)
+			for range 2 {
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				r.RemoteAddr = "10.0.0.1:1234"
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				assert.Equal(t, tt.statusCode, w.Code)
+			}
+			count, _ := calls.Load(path)
+			assert.Equal(t, tt.wantCalls, count.(*atomic.Int32).Load())
+		})
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.requests.WithLabelValues("anonymous", "negative_hit")))
+	assert.NoError(t, testutil.CollectAndCompare(negativeMetrics, strings.NewReader(`
+# HELP http_cache_negative_hit_total Number of times a negative response was served from the cache
+# TYPE http_cache_negative_hit_total counter
+http_cache_negative_hit_total{code="404",method="GET"} 1
+http_cache_negative_hit_total{code="422",method="GET"} 1
+`)))
+	stats, err := backend.Stats(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Keys)
+}
+
+func TestTMDBProxyHandler_OfflineStale(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+
+	backend := NewMemoryCache(0, 0)
+	h := TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Millisecond, StaleIfError: time.Hour}, discardLogger)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/3/movie/550", nil))
+	time.Sleep(10 * time.Millisecond)
+
+	h = TMDBProxyHandler(backend, Options{Target: "http://localhost:1", Offline: true}, discardLogger)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/550", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+	assert.Equal(t, `111 - "Revalidation Failed"`, w.Header().Get("Warning"))
+}
+
+func TestTMDBProxyHandler_RecordReplay(t *testing.T) {
+	s := tmdbtest.NewServer()
+	s.AddMovie(tmdb.Movie{Id: 550, Title: "Fight Club"})
+
+	dir := t.TempDir()
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Hour, Transport: &tmdbrecord.Recorder{Directory: dir}}, discardLogger)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/550", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	recorded := w.Body.String()
+	s.Close()
+
+	// a proxy with an empty cache serves the recorded response, and fails for anything else
+	breaker := NewCircuitBreaker(0.5, 0, time.Hour, "", "", nil)
+	breaker.MinCalls = 2
+	h = TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Hour, Transport: tmdbrecord.Replayer{Directory: dir}, Breaker: breaker}, discardLogger)
+	for _, target := range []string{"/3/movie/551", "/3/movie/552", "/3/movie/553"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Contains(t, w.Body.String(), "not recorded")
+	}
+
+	// requests that weren't recorded don't open the circuit breaker
+	state, _ := breaker.State()
+	assert.Equal(t, "closed", state)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/550", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, recorded, w.Body.String())
+}
+
+func TestTMDBProxyHandler_Forwarding(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// hop-by-hop headers of the client are not forwarded
+		for _, header := range []string{"Keep-Alive", "Te", "Upgrade", "X-Hop", "Proxy-Authorization"} {
+			if r.Header.Get(header) != "" {
+				http.Error(w, header+" forwarded", http.StatusBadRequest)
+				return
+			}
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "unexpected method "+r.Method, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Via", strings.Join(r.Header.Values("Via"), ", "))
+		w.Header().Set("X-Forwarded", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-End-To-End", r.Header.Get("X-End-To-End"))
+		w.Header().Set("Content-Type", "application/json;charset=utf-8")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		_, _ = w.Write([]byte(`{"id":550}`))
+	}))
+	t.Cleanup(s.Close)
+
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Hour}, discardLogger)
+
+	r := httptest.NewRequest(http.MethodGet, "/3/movie/550", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("Connection", "keep-alive, X-Hop")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	r.Header.Set("Te", "trailers")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	r.Header.Set("X-Hop", "foo")
+	r.Header.Set("X-End-To-End", "bar")
+	r.Header.Set("Via", "1.1 cdn")
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "1.1 cdn, 1.1 tmdb-proxy", w.Header().Get("X-Via"))
+	assert.Equal(t, "1.2.3.4, 10.0.0.1", w.Header().Get("X-Forwarded"))
+	assert.Equal(t, "bar", w.Header().Get("X-End-To-End"))
+	// tmdb's hop-by-hop headers are not forwarded to the client either
+	assert.Empty(t, w.Header().Get("Keep-Alive"))
+	assert.Equal(t, "application/json;charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "10", w.Header().Get("Content-Length"))
+	assert.Equal(t, []string{"1.1 tmdb-proxy"}, w.Header().Values("Via"))
+	assert.Equal(t, `{"id":550}`, w.Body.String())
+
+	// HEAD is served from the cached GET response, without a body
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/3/movie/550", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "application/json;charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "10", w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, int32(1), calls.Load())
+
+	// a HEAD miss gets, and caches, the GET response
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/3/movie/551", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Empty(t, w.Body.String())
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/551", nil))
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, `{"id":550}`, w.Body.String())
+	assert.Equal(t, int32(2), calls.Load())
+
+	// other methods are not allowed
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/3/movie/550", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code, method)
+		assert.Equal(t, "GET, HEAD", w.Header().Get("Allow"))
+	}
+	assert.Equal(t, int32(2), calls.Load())
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RedisClient interface {
+	Set(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd
+	Get(ctx context.Context, key string) *redis.StringCmd
+	PTTL(ctx context.Context, key string) *redis.DurationCmd
+	Ping(ctx context.Context) *redis.StatusCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
+	Info(ctx context.Context, section ...string) *redis.StringCmd
+}
+
+var _ AdminBackend = &RedisCache{}
+
+// RedisCache stores cached responses in Redis.
+type RedisCache struct {
+	Client RedisClient
+}
+
+func NewRedisCache(client RedisClient) *RedisCache {
+	return &RedisCache{Client: client}
+}
+
+func (r *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := r.Client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		err = ErrNotFound
+	}
+	return value, err
+}
+
+// TTL returns the time until the key expires, or 0 if it never expires.
+func (r *RedisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.Client.PTTL(ctx, key).Result()
+	switch {
+	case err != nil:
+		return 0, err
+	case ttl == -1:
+		// the key has no expiry
+		return 0, nil
+	case ttl <= 0:
+		// the key doesn't exist (-2), or is about to expire
+		return 0, ErrNotFound
+	}
+	return ttl, nil
+}
+
+func (r *RedisCache) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	return r.Client.Set(ctx, key, value, expiration).Err()
+}
+
+func (r *RedisCache) Ping(ctx context.Context) error {
+	return r.Client.Ping(ctx).Err()
+}
+
+func (r *RedisCache) Delete(ctx context.Context, key string) error {
+	return r.Client.Del(ctx, key).Err()
+}
+
+func (r *RedisCache) Scan(ctx context.Context, prefix string, f func(key string) error) error {
+	match := redisGlobEscaper.Replace(prefix) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := r.Client.Scan(ctx, cursor, match, 1000).Result()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err = f(key); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// Stats returns the number of keys in the proxy's namespace and the memory used by the Redis server. Other
+// applications sharing the database aren't counted, but Redis doesn't report the memory used per key: Bytes is the
+// memory used by the whole server.
+func (r *RedisCache) Stats(ctx context.Context) (BackendStats, error) {
+	var stats BackendStats
+	if err := r.Scan(ctx, cacheNamespace+"|", func(string) error {
+		stats.Keys++
+		return nil
+	}); err != nil {
+		return BackendStats{}, err
+	}
+	info, err := r.Client.Info(ctx, "memory").Result()
+	if err != nil {
+		return BackendStats{}, err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "used_memory:"); ok {
+			stats.Bytes, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return stats, nil
+}
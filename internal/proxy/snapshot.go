@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// snapshotRecord is a cached response in a snapshot archive.
+type snapshotRecord struct {
+	Key        string      `json:"key"`
+	URL        string      `json:"url"`
+	StoredAt   time.Time   `json:"stored_at"`
+	FreshUntil time.Time   `json:"fresh_until"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	// Gzipped indicates that Body is gzip-compressed.
+	Gzipped bool   `json:"gzipped"`
+	Body    []byte `json:"body"`
+}
+
+// ExportSnapshot writes all responses cached by the proxy to w, as a gzip-compressed tar archive holding one JSON
+// record per response. Entries of other applications sharing the backend are skipped. It returns the number of
+// exported responses.
+func ExportSnapshot(ctx context.Context, backend AdminBackend, w io.Writer) (int, error) {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	var count int
+	err := backend.Scan(ctx, cacheNamespace+"|", func(key string) error {
+		value, err := backend.Get(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			// expired since the scan
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var entry cachedResponse
+		if err = entry.unmarshal(value); err != nil {
+			// entries written by other versions of the proxy aren't exported
+			return nil
+		}
+		record, err := json.Marshal(snapshotRecord{
+			Key:        key,
+			URL:        strings.TrimPrefix(key, cacheNamespace+"|"+http.MethodGet+"|"),
+			StoredAt:   entry.storedAt,
+			FreshUntil: entry.freshUntil,
+			StatusCode: entry.statusCode,
+			Header:     entry.header,
+			Gzipped:    entry.gzipped,
+			Body:       entry.body,
+		})
+		if err != nil {
+			return err
+		}
+		count++
+		if err = tw.WriteHeader(&tar.Header{
+			Name:    fmt.Sprintf("entries/%08d.json", count),
+			Mode:    0o644,
+			Size:    int64(len(record)),
+			ModTime: entry.storedAt,
+		}); err == nil {
+			_, err = tw.Write(record)
+		}
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	return count, err
+}
+
+// ImportSnapshot stores the responses of a snapshot archive, as written by ExportSnapshot, in backend. If ttl is set,
+// the responses are fresh for ttl after the import. Otherwise, they stay fresh for as long as they were when they were
+// exported.
+//
+// Responses are kept in backend for keep after they become stale, so an offline proxy can still serve them. If keep
+// is zero, they never expire. Responses that were stale for longer than keep are skipped. Negative responses (e.g. a
+// 404) are never served stale: they expire once they're no longer fresh, whatever keep is. It returns the number of
+// imported responses.
+func ImportSnapshot(ctx context.Context, backend Backend, r io.Reader, ttl, keep time.Duration) (int, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("snapshot: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	var count int
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("snapshot: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, ".json") {
+			continue
+		}
+		var record snapshotRecord
+		if err = json.NewDecoder(tr).Decode(&record); err != nil {
+			return count, fmt.Errorf("snapshot: %s: %w", hdr.Name, err)
+		}
+		if !strings.HasPrefix(record.Key, cacheNamespace+"|") {
+			return count, fmt.Errorf("snapshot: %s: invalid key %q", hdr.Name, record.Key)
+		}
+		entry := cachedResponse{
+			storedAt:   record.StoredAt,
+			freshUntil: record.FreshUntil,
+			statusCode: record.StatusCode,
+			header:     record.Header,
+			body:       record.Body,
+			gzipped:    record.Gzipped,
+		}
+		if entry.header == nil {
+			entry.header = make(http.Header)
+		}
+		if ttl > 0 {
+			entry.freshUntil = time.Now().Add(ttl)
+		}
+		// negative responses are never served stale: they expire once they're no longer fresh
+		expiry := entry.freshUntil
+		if entry.statusCode == http.StatusOK {
+			expiry = time.Time{}
+			if keep > 0 {
+				expiry = entry.freshUntil.Add(keep)
+			}
+		}
+		var expiration time.Duration
+		if !expiry.IsZero() {
+			if expiration = time.Until(expiry); expiration <= 0 {
+				continue
+			}
+		}
+		if err = backend.Set(ctx, record.Key, entry.marshal(), expiration); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/3/movie/0" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+	}))
+	t.Cleanup(s.Close)
+
+	source := NewMemoryCache(0, 0)
+	h := TMDBProxyHandler(source, Options{Target: s.URL, TTL: time.Hour, NegativeTTL: time.Hour}, discardLogger)
+	for _, target := range []string{"/3/movie/550", "/3/person/287?language=nl-BE", "/3/movie/0"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	// entries of other applications sharing the cache are not exported
+	require.NoError(t, source.Set(t.Context(), "other|GET|/3/movie/550", []byte("foo"), time.Hour))
+
+	var snapshot bytes.Buffer
+	count, err := ExportSnapshot(t.Context(), source, &snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	target := NewMemoryCache(0, 0)
+	count, err = ImportSnapshot(t.Context(), target, bytes.NewReader(snapshot.Bytes()), 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// offline, the proxy serves the imported responses and never calls TMDB
+	h = TMDBProxyHandler(target, Options{Target: "http://localhost:1", Offline: true}, discardLogger)
+	tests := []struct {
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{target: "/3/movie/550?language=en-US", wantCode: http.StatusOK, wantBody: `{"path":"/3/movie/550"}`},
+		{target: "/3/person/287?language=nl-BE", wantCode: http.StatusOK, wantBody: `{"path":"/3/person/287"}`},
+		{target: "/3/movie/0", wantCode: http.StatusNotFound},
+		{target: "/3/movie/551", wantCode: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+				assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+			}
+		})
+	}
+
+	_, err = ImportSnapshot(t.Context(), target, bytes.NewReader([]byte("not a snapshot")), 0, 0)
+	assert.Error(t, err)
+}
+
+func TestSnapshot_Stale(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+
+	source := NewMemoryCache(0, 0)
+	h := TMDBProxyHandler(source, Options{Target: s.URL, TTL: time.Millisecond, StaleIfError: time.Hour}, discardLogger)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/3/movie/550", nil))
+	time.Sleep(10 * time.Millisecond)
+
+	var snapshot bytes.Buffer
+	count, err := ExportSnapshot(t.Context(), source, &snapshot)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// stale responses are kept for offline use
+	target := NewMemoryCache(0, 0)
+	count, err = ImportSnapshot(t.Context(), target, bytes.NewReader(snapshot.Bytes()), 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	h = TMDBProxyHandler(target, Options{Offline: true}, discardLogger)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/550", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+
+	// unless they've been stale for longer than keep
+	count, err = ImportSnapshot(t.Context(), NewMemoryCache(0, 0), bytes.NewReader(snapshot.Bytes()), 0, time.Millisecond)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	// the import can renew them
+	target = NewMemoryCache(0, 0)
+	count, err = ImportSnapshot(t.Context(), target, bytes.NewReader(snapshot.Bytes()), time.Hour, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	h = TMDBProxyHandler(target, Options{Offline: true}, discardLogger)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/550", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+}
+
+func TestSnapshot_Negative(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(s.Close)
+
+	source := NewMemoryCache(0, 0)
+	h := TMDBProxyHandler(source, Options{Target: s.URL, TTL: time.Hour, NegativeTTL: 50 * time.Millisecond}, discardLogger)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/3/movie/0", nil))
+
+	var snapshot bytes.Buffer
+	count, err := ExportSnapshot(t.Context(), source, &snapshot)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// keep doesn't apply to negative responses: they expire once they're no longer fresh
+	target := NewMemoryCache(0, 0)
+	count, err = ImportSnapshot(t.Context(), target, bytes.NewReader(snapshot.Bytes()), 0, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	h = TMDBProxyHandler(target, Options{Offline: true, StaleIfError: time.Hour}, discardLogger)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/0", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	time.Sleep(100 * time.Millisecond)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/0", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+	// nor are they imported once they're no longer fresh
+	count, err = ImportSnapshot(t.Context(), NewMemoryCache(0, 0), bytes.NewReader(snapshot.Bytes()), 0, time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
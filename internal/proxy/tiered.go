@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var _ AdminBackend = &TieredCache{}
+
+// TieredCache serves cached responses from an in-memory L1 cache, falling back to a shared L2 cache (e.g. Redis).
+// Entries only live in L1 for L1TTL, so replicas never serve outdated data for long, and never outlive their L2 copy
+// if L2 reports how long its entries have left (see MemoryCache.TTL, DiskCache.TTL and RedisCache.TTL). If an
+// Invalidator is configured, entries written by one replica are evicted from the L1 caches of all other replicas.
+type TieredCache struct {
+	L1          *MemoryCache
+	L1TTL       time.Duration
+	L2          Backend
+	Invalidator Invalidator
+	Logger      *slog.Logger
+}
+
+func (t *TieredCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, err := t.L1.Get(ctx, key); err == nil {
+		return value, nil
+	}
+	value, err := t.L2.Get(ctx, key)
+	if err == nil {
+		if l1TTL, ok := t.l1TTL(ctx, key); ok {
+			_ = t.L1.Set(ctx, key, value, l1TTL)
+		}
+	}
+	return value, err
+}
+
+// ttlBackend is a Backend that reports how long an entry has left before it expires.
+type ttlBackend interface {
+	// TTL returns the time until the key expires, or 0 if it never expires.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+}
+
+// l1TTL returns how long an entry copied from L2 may live in L1: L1TTL, or less if the L2 entry expires sooner. It
+// returns false if the entry shouldn't be copied, e.g. because it expired in the meantime.
+func (t *TieredCache) l1TTL(ctx context.Context, key string) (time.Duration, bool) {
+	l2, ok := t.L2.(ttlBackend)
+	if !ok {
+		return t.L1TTL, true
+	}
+	remaining, err := l2.TTL(ctx, key)
+	if err != nil {
+		return 0, false
+	}
+	if remaining > 0 {
+		return min(t.L1TTL, remaining), true
+	}
+	return t.L1TTL, true
+}
+
+func (t *TieredCache) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	if err := t.L2.Set(ctx, key, value, expiration); err != nil {
+		return err
+	}
+	// entries that never expire in L2 must still expire in L1, in case an invalidation is missed
+	l1TTL := t.L1TTL
+	if expiration > 0 {
+		l1TTL = min(l1TTL, expiration)
+	}
+	_ = t.L1.Set(ctx, key, value, l1TTL)
+	if t.Invalidator != nil {
+		if err := t.Invalidator.Publish(ctx, key); err != nil {
+			t.Logger.Warn("failed to publish cache invalidation", "err", err)
+		}
+	}
+	return nil
+}
+
+func (t *TieredCache) Ping(ctx context.Context) error {
+	return t.L2.Ping(ctx)
+}
+
+// Delete removes the key from L2 and from the L1 caches of all replicas.
+func (t *TieredCache) Delete(ctx context.Context, key string) error {
+	l2, ok := t.L2.(AdminBackend)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if err := l2.Delete(ctx, key); err != nil {
+		return err
+	}
+	_ = t.L1.Delete(ctx, key)
+	if t.Invalidator != nil {
+		if err := t.Invalidator.Publish(ctx, key); err != nil {
+			t.Logger.Warn("failed to publish cache invalidation", "err", err)
+		}
+	}
+	return nil
+}
+
+// Scan calls f for every key in L2 that starts with prefix.
+func (t *TieredCache) Scan(ctx context.Context, prefix string, f func(key string) error) error {
+	l2, ok := t.L2.(AdminBackend)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return l2.Scan(ctx, prefix, f)
+}
+
+// Stats returns the stats of L2.
+func (t *TieredCache) Stats(ctx context.Context) (BackendStats, error) {
+	l2, ok := t.L2.(AdminBackend)
+	if !ok {
+		return BackendStats{}, errors.ErrUnsupported
+	}
+	return l2.Stats(ctx)
+}
+
+// Run evicts the L1 entries invalidated by other replicas, until the context is canceled.
+// Run does nothing if no Invalidator is configured.
+func (t *TieredCache) Run(ctx context.Context) error {
+	if t.Invalidator == nil {
+		return nil
+	}
+	return t.Invalidator.Subscribe(ctx, func(key string) {
+		_ = t.L1.Delete(ctx, key)
+	})
+}
+
+// An Invalidator notifies other replicas that a cache entry has changed.
+type Invalidator interface {
+	// Publish notifies all other replicas that the entry for key has changed.
+	Publish(ctx context.Context, key string) error
+	// Subscribe calls f for each key published by another replica, until the context is canceled.
+	Subscribe(ctx context.Context, f func(key string)) error
+}
+
+type RedisPubSubClient interface {
+	Publish(ctx context.Context, channel string, message any) *redis.IntCmd
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+var _ Invalidator = &RedisInvalidator{}
+
+// RedisInvalidator implements Invalidator using Redis pub/sub.
+type RedisInvalidator struct {
+	Client  RedisPubSubClient
+	Channel string
+	// origin identifies the replica, so it can ignore its own invalidations.
+	origin string
+}
+
+func NewRedisInvalidator(client RedisPubSubClient, channel string) *RedisInvalidator {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return &RedisInvalidator{Client: client, Channel: channel, origin: hex.EncodeToString(id[:])}
+}
+
+func (r *RedisInvalidator) Publish(ctx context.Context, key string) error {
+	return r.Client.Publish(ctx, r.Channel, r.origin+"\n"+key).Err()
+}
+
+func (r *RedisInvalidator) Subscribe(ctx context.Context, f func(key string)) error {
+	sub := r.Client.Subscribe(ctx, r.Channel)
+	defer func() { _ = sub.Close() }()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return errors.New("redis subscription closed")
+			}
+			if origin, key, found := strings.Cut(msg.Payload, "\n"); found && origin != r.origin {
+				f(key)
+			}
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/clambin/go-common/cache"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTieredCache(t *testing.T) {
+	l2 := NewRedisCache(&fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)})
+	var bus fakeInvalidationBus
+	replicas := make([]*TieredCache, 2)
+	for i := range replicas {
+		replicas[i] = &TieredCache{
+			L1:          NewMemoryCache(0, 0),
+			L1TTL:       time.Hour,
+			L2:          l2,
+			Invalidator: bus.newInvalidator(),
+			Logger:      discardLogger,
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	for _, replica := range replicas {
+		go func() { _ = replica.Run(ctx) }()
+	}
+	require.Eventually(t, func() bool { return bus.subscribers() == len(replicas) }, time.Second, time.Millisecond)
+
+	// replica 0 stores an entry. replica 1 finds it in L2 and copies it to its L1
+	require.NoError(t, replicas[0].Set(ctx, "foo", []byte("bar"), time.Hour))
+	value, err := replicas[1].Get(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+	_, err = replicas[1].L1.Get(ctx, "foo")
+	require.NoError(t, err)
+
+	// replica 0 updates the entry: replica 1's L1 copy is evicted
+	require.NoError(t, replicas[0].Set(ctx, "foo", []byte("snafu"), time.Hour))
+	assert.Eventually(t, func() bool {
+		_, err := replicas[1].L1.Get(ctx, "foo")
+		return err != nil
+	}, time.Second, time.Millisecond)
+	value, err = replicas[1].Get(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "snafu", string(value))
+
+	// replica 0 keeps its own copy
+	_, err = replicas[0].L1.Get(ctx, "foo")
+	assert.NoError(t, err)
+
+	// replica 1 deletes the entry: it's removed from L2 and from all L1 caches
+	require.NoError(t, replicas[1].Delete(ctx, "foo"))
+	assert.Eventually(t, func() bool {
+		_, err := replicas[0].Get(ctx, "foo")
+		return err != nil
+	}, time.Second, time.Millisecond)
+
+	_, err = replicas[0].Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, replicas[0].Ping(ctx))
+}
+
+func TestTieredCache_L1TTL(t *testing.T) {
+	l2 := NewMemoryCache(0, 0)
+	c := TieredCache{L1: NewMemoryCache(0, 0), L1TTL: time.Millisecond, L2: l2, Logger: discardLogger}
+	ctx := context.Background()
+
+	// an update that bypasses this replica only becomes visible once the L1 entry expires, even if the L2 entry
+	// doesn't expire
+	for _, expiration := range []time.Duration{time.Hour, 0} {
+		require.NoError(t, c.Set(ctx, "foo", []byte("bar"), expiration))
+		require.NoError(t, l2.Set(ctx, "foo", []byte("snafu"), expiration))
+		assert.Eventually(t, func() bool {
+			value, err := c.Get(ctx, "foo")
+			return err == nil && string(value) == "snafu"
+		}, time.Second, time.Millisecond)
+	}
+	assert.NoError(t, c.Run(ctx))
+}
+
+func TestTieredCache_L2Expiry(t *testing.T) {
+	l2 := NewRedisCache(&fakeRedisClient{cache: cache.New[string, string](time.Hour, time.Hour)})
+	c := TieredCache{L1: NewMemoryCache(0, 0), L1TTL: time.Hour, L2: l2, Logger: discardLogger}
+	ctx := context.Background()
+
+	// an entry copied from L2 doesn't outlive its L2 copy
+	require.NoError(t, l2.Set(ctx, "foo", []byte("bar"), 50*time.Millisecond))
+	_, err := c.Get(ctx, "foo")
+	require.NoError(t, err)
+	ttl, err := c.L1.TTL(ctx, "foo")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, ttl, 50*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, err := c.Get(ctx, "foo")
+		return errors.Is(err, ErrNotFound)
+	}, time.Second, 10*time.Millisecond)
+
+	// entries that never expire in L2 live in L1 for L1TTL
+	require.NoError(t, l2.Set(ctx, "foo", []byte("bar"), 0))
+	_, err = c.Get(ctx, "foo")
+	require.NoError(t, err)
+	ttl, err = c.L1.TTL(ctx, "foo")
+	require.NoError(t, err)
+	assert.InDelta(t, time.Hour, ttl, float64(time.Minute))
+}
+
+func TestRedisInvalidator(t *testing.T) {
+	server := newFakePubSubServer(t)
+	newClient := func() *redis.Client {
+		c := redis.NewClient(&redis.Options{Addr: server.Addr().String(), DisableIndentity: true})
+		t.Cleanup(func() { _ = c.Close() })
+		return c
+	}
+	replica1 := NewRedisInvalidator(newClient(), "invalidate")
+	replica2 := NewRedisInvalidator(newClient(), "invalidate")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	keys := make(chan string, 10)
+	done := make(chan error)
+	go func() { done <- replica1.Subscribe(ctx, func(key string) { keys <- key }) }()
+	require.Eventually(t, func() bool { return server.subscribers() == 1 }, time.Second, time.Millisecond)
+
+	// replicas ignore their own invalidations
+	require.NoError(t, replica1.Publish(ctx, "foo"))
+	require.NoError(t, replica2.Publish(ctx, "bar"))
+	assert.Equal(t, "bar", <-keys)
+	assert.Empty(t, keys)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+// fakePubSubServer implements the part of the Redis protocol used by RedisInvalidator: SUBSCRIBE and PUBLISH.
+type fakePubSubServer struct {
+	net.Listener
+	lock  sync.Mutex
+	conns map[string][]net.Conn
+}
+
+func newFakePubSubServer(t *testing.T) *fakePubSubServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	s := fakePubSubServer{Listener: l, conns: make(map[string][]net.Conn)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return &s
+}
+
+func (s *fakePubSubServer) subscribers() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var count int
+	for _, conns := range s.conns {
+		count += len(conns)
+	}
+	return count
+}
+
+func (s *fakePubSubServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "SUBSCRIBE":
+			for _, channel := range args[1:] {
+				s.conns[channel] = append(s.conns[channel], conn)
+				_, _ = conn.Write([]byte(respArray("subscribe", channel) + ":1\r\n"))
+			}
+		case "PUBLISH":
+			for _, subscriber := range s.conns[args[1]] {
+				_, _ = subscriber.Write([]byte(respArray("message", args[1]) + respBulk(args[2])))
+			}
+			_, _ = fmt.Fprintf(conn, ":%d\r\n", len(s.conns[args[1]]))
+		case "PING":
+			_, _ = conn.Write([]byte("+PONG\r\n"))
+		default:
+			_, _ = conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+		s.lock.Unlock()
+	}
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	var count int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &count); err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+// respArray returns the header of a 3-element array, followed by its first two elements.
+func respArray(kind, channel string) string {
+	return "*3\r\n" + respBulk(kind) + respBulk(channel)
+}
+
+func respBulk(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+type fakeInvalidationBus struct {
+	lock     sync.Mutex
+	channels []chan string
+}
+
+func (b *fakeInvalidationBus) newInvalidator() *fakeInvalidator {
+	return &fakeInvalidator{bus: b}
+}
+
+func (b *fakeInvalidationBus) subscribers() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.channels)
+}
+
+var _ Invalidator = &fakeInvalidator{}
+
+type fakeInvalidator struct {
+	bus *fakeInvalidationBus
+	ch  chan string
+}
+
+func (f *fakeInvalidator) Publish(_ context.Context, key string) error {
+	f.bus.lock.Lock()
+	defer f.bus.lock.Unlock()
+	for _, ch := range f.bus.channels {
+		if ch != f.ch {
+			ch <- key
+		}
+	}
+	return nil
+}
+
+func (f *fakeInvalidator) Subscribe(ctx context.Context, fn func(key string)) error {
+	f.bus.lock.Lock()
+	f.ch = make(chan string, 10)
+	f.bus.channels = append(f.bus.channels, f.ch)
+	f.bus.lock.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case key := <-f.ch:
+			fn(key)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A TTLRule sets the TTL of all responses whose path matches Pattern, as per path.Match (e.g. "/3/movie/*/credits").
+// A '*' matches a single path segment: "/3/configuration/*" matches "/3/configuration/languages", but not
+// "/3/configuration" or "/3/configuration/languages/extra".
+type TTLRule struct {
+	Pattern string
+	TTL     time.Duration
+}
+
+// ParseTTLRules reads TTL rules, one per line, in the format "pattern: ttl". TTLs are specified as a Go duration
+// (e.g. "72h") or a number of days (e.g. "7d"). Empty lines and lines starting with '#' are ignored.
+func ParseTTLRules(r io.Reader) ([]TTLRule, error) {
+	var rules []TTLRule
+	scanner := bufio.NewScanner(r)
+	var lineNo int
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing ttl", lineNo)
+		}
+		pattern := strings.TrimSpace(line[:i])
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q: %w", lineNo, pattern, err)
+		}
+		ttl, err := parseTTL(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		rules = append(rules, TTLRule{Pattern: pattern, TTL: ttl})
+	}
+	return rules, scanner.Err()
+}
+
+func parseTTL(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ttl %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// cachePolicy determines how long a response is fresh. The first TTLRule that matches the request's path is used.
+// If no rule matches, the default TTL applies. If honorMaxAge is set, a max-age in the response's Cache-Control
+// header overrides the TTL.
+type cachePolicy struct {
+	defaultTTL  time.Duration
+	rules       []TTLRule
+	honorMaxAge bool
+}
+
+// ttl returns the TTL for the response and a description of the rule that determined it. If the response should
+// not be cached, ttl returns false.
+func (p cachePolicy) ttl(r *http.Request, resp *http.Response) (time.Duration, string, bool) {
+	if p.honorMaxAge {
+		if maxAge, ok, err := parseMaxAge(resp.Header); err == nil && ok {
+			return maxAge, "max-age", maxAge > 0
+		} else if errors.Is(err, errNoStore) {
+			return 0, "no-store", false
+		}
+	}
+	for _, rule := range p.rules {
+		if ok, _ := path.Match(rule.Pattern, r.URL.Path); ok {
+			return rule.TTL, rule.Pattern, true
+		}
+	}
+	return p.defaultTTL, "default", true
+}
+
+var errNoStore = errors.New("no-store")
+
+// parseMaxAge returns the TTL specified by a Cache-Control header. s-maxage, which applies to shared caches like ours,
+// takes precedence over max-age.
+func parseMaxAge(h http.Header) (time.Duration, bool, error) {
+	var maxAge, sMaxAge string
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "private":
+			return 0, false, errNoStore
+		case "max-age":
+			maxAge = value
+		case "s-maxage":
+			sMaxAge = value
+		}
+	}
+	if value := cmp.Or(sMaxAge, maxAge); value != "" {
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil {
+			return 0, false, err
+		}
+		return time.Duration(seconds) * time.Second, true, nil
+	}
+	return 0, false, nil
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTTLRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []TTLRule
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name: "valid",
+			input: `# reference data
+/3/configuration/*: 7d
+/3/genre/*/list: 168h
+
+/3/movie/*/credits : 72h
+`,
+			want: []TTLRule{
+				{Pattern: "/3/configuration/*", TTL: 7 * 24 * time.Hour},
+				{Pattern: "/3/genre/*/list", TTL: 168 * time.Hour},
+				{Pattern: "/3/movie/*/credits", TTL: 72 * time.Hour},
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "missing ttl",
+			input:   "/3/movie/*",
+			wantErr: assert.Error,
+		},
+		{
+			name:    "invalid ttl",
+			input:   "/3/movie/*: soon",
+			wantErr: assert.Error,
+		},
+		{
+			name:    "invalid pattern",
+			input:   "/3/movie/[: 1h",
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseTTLRules(strings.NewReader(tt.input))
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, rules)
+		})
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	policy := cachePolicy{
+		defaultTTL: 24 * time.Hour,
+		rules: []TTLRule{
+			{Pattern: "/3/trending/*/*", TTL: time.Hour},
+			{Pattern: "/3/movie/*/credits", TTL: 72 * time.Hour},
+		},
+	}
+	tests := []struct {
+		name          string
+		path          string
+		honorMaxAge   bool
+		cacheControl  string
+		wantTTL       time.Duration
+		wantRule      string
+		wantCacheable bool
+	}{
+		{name: "default", path: "/3/movie/1", wantTTL: 24 * time.Hour, wantRule: "default", wantCacheable: true},
+		{name: "rule", path: "/3/movie/1/credits", wantTTL: 72 * time.Hour, wantRule: "/3/movie/*/credits", wantCacheable: true},
+		{name: "single segment", path: "/3/movie/1/2/credits", wantTTL: 24 * time.Hour, wantRule: "default", wantCacheable: true},
+		{name: "no sub-path", path: "/3/movie/1/credits/extra", wantTTL: 24 * time.Hour, wantRule: "default", wantCacheable: true},
+		{name: "max-age ignored", path: "/3/trending/movie/day", cacheControl: "public, max-age=60", wantTTL: time.Hour, wantRule: "/3/trending/*/*", wantCacheable: true},
+		{name: "max-age", path: "/3/trending/movie/day", honorMaxAge: true, cacheControl: "public, max-age=60", wantTTL: time.Minute, wantRule: "max-age", wantCacheable: true},
+		{name: "s-maxage", path: "/3/movie/1", honorMaxAge: true, cacheControl: "max-age=60, s-maxage=120", wantTTL: 2 * time.Minute, wantRule: "max-age", wantCacheable: true},
+		{name: "max-age zero", path: "/3/movie/1", honorMaxAge: true, cacheControl: "max-age=0", wantRule: "max-age"},
+		{name: "no-store", path: "/3/movie/1", honorMaxAge: true, cacheControl: "no-store", wantRule: "no-store"},
+		{name: "no max-age", path: "/3/movie/1", honorMaxAge: true, cacheControl: "public", wantTTL: 24 * time.Hour, wantRule: "default", wantCacheable: true},
+		{name: "invalid max-age", path: "/3/movie/1", honorMaxAge: true, cacheControl: "max-age=foo", wantTTL: 24 * time.Hour, wantRule: "default", wantCacheable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := policy
+			p.honorMaxAge = tt.honorMaxAge
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			resp := http.Response{Header: http.Header{}}
+			if tt.cacheControl != "" {
+				resp.Header.Set("Cache-Control", tt.cacheControl)
+			}
+			ttl, rule, cacheable := p.ttl(r, &resp)
+			assert.Equal(t, tt.wantTTL, ttl)
+			assert.Equal(t, tt.wantRule, rule)
+			assert.Equal(t, tt.wantCacheable, cacheable)
+		})
+	}
+}
+
+func TestTMDBProxyHandler_TTLPolicy(t *testing.T) {
+	var calls int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/3/movie/now_playing" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+
+	h := TMDBProxyHandler(NewMemoryCache(0, 0), Options{
+		Target:      s.URL,
+		TTL:         time.Hour,
+		TTLRules:    []TTLRule{{Pattern: "/3/movie/*/credits", TTL: 72 * time.Hour}},
+		HonorMaxAge: true,
+	}, discardLogger)
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/1/credits", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "rule=/3/movie/*/credits; ttl=259200", w.Header().Get("X-Cache-Policy"))
+	}
+	assert.Equal(t, 1, calls)
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/now_playing", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-Cache-Policy"))
+	}
+	assert.Equal(t, 3, calls)
+}
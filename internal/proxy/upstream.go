@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultRetryAfter is how long the proxy backs off when TMDB throttles it without a valid Retry-After header.
+const defaultRetryAfter = 10 * time.Second
+
+// UpstreamLimiter limits the rate of calls to TMDB across all clients. When TMDB throttles the proxy (429 Too Many
+// Requests), UpstreamLimiter stops all calls until the time set by TMDB's Retry-After header has passed.
+//
+// Calls wait for their turn for up to QueueTimeout. Calls that would have to wait longer are shed.
+type UpstreamLimiter struct {
+	QueueTimeout time.Duration
+
+	limiter      *rate.Limiter
+	lock         sync.Mutex
+	blockedUntil time.Time
+	queued       atomic.Int64
+	throttled    prometheus.Counter
+	shed         prometheus.Counter
+	queueLength  prometheus.GaugeFunc
+	backoff      prometheus.GaugeFunc
+}
+
+// NewUpstreamLimiter returns an UpstreamLimiter that allows limit calls per second, with bursts of up to burst calls.
+// A zero limit means no limit. Its metrics use the provided namespace, subsystem and constLabels.
+func NewUpstreamLimiter(limit rate.Limit, burst int, queueTimeout time.Duration, namespace, subsystem string, constLabels prometheus.Labels) *UpstreamLimiter {
+	l := UpstreamLimiter{
+		QueueTimeout: queueTimeout,
+		limiter:      newLimiter(limit, burst),
+		throttled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "upstream_throttled_total",
+			Help:        "Number of 429 responses received from TMDB",
+			ConstLabels: constLabels,
+		}),
+		shed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "upstream_shed_total",
+			Help:        "Number of requests rejected because the upstream rate limit was reached",
+			ConstLabels: constLabels,
+		}),
+	}
+	l.queueLength = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   subsystem,
+		Name:        "upstream_queued_requests",
+		Help:        "Number of requests waiting for the upstream rate limit",
+		ConstLabels: constLabels,
+	}, func() float64 { return float64(l.queued.Load()) })
+	l.backoff = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   subsystem,
+		Name:        "upstream_backoff_seconds",
+		Help:        "Time until TMDB's Retry-After period ends (0: not throttled)",
+		ConstLabels: constLabels,
+	}, func() float64 { return l.BackoffRemaining().Seconds() })
+	return &l
+}
+
+// ThrottledError is returned when a call to TMDB was not made, because the upstream rate limit was reached or
+// because TMDB is throttling the proxy.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("tmdb rate limit reached: retry after %s", e.RetryAfter)
+}
+
+// wait blocks until a call to TMDB is allowed. If the call would have to wait longer than QueueTimeout, wait returns
+// a ThrottledError.
+func (l *UpstreamLimiter) wait(ctx context.Context) error {
+	queueTimeout := cmp.Or(l.QueueTimeout, 5*time.Second)
+	l.queued.Add(1)
+	defer l.queued.Add(-1)
+
+	if backoff := l.BackoffRemaining(); backoff > 0 {
+		if backoff > queueTimeout {
+			l.shed.Inc()
+			return &ThrottledError{RetryAfter: backoff}
+		}
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+	}
+
+	r := l.limiter.Reserve()
+	delay := r.Delay()
+	if !r.OK() || delay > queueTimeout {
+		r.Cancel()
+		l.shed.Inc()
+		return &ThrottledError{RetryAfter: max(delay, time.Second)}
+	}
+	if err := sleep(ctx, delay); err != nil {
+		r.Cancel()
+		return err
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttle stops all calls to TMDB for the period set by the response's Retry-After header.
+func (l *UpstreamLimiter) throttle(resp *http.Response) time.Duration {
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+	l.throttled.Inc()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if until := time.Now().Add(retryAfter); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+	return retryAfter
+}
+
+// BackoffRemaining returns how long the proxy still backs off after TMDB throttled it.
+func (l *UpstreamLimiter) BackoffRemaining() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return max(0, time.Until(l.blockedUntil))
+}
+
+// parseRetryAfter parses a Retry-After header, which holds either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+	return defaultRetryAfter
+}
+
+func (l *UpstreamLimiter) Describe(ch chan<- *prometheus.Desc) {
+	l.throttled.Describe(ch)
+	l.shed.Describe(ch)
+	l.queueLength.Describe(ch)
+	l.backoff.Describe(ch)
+}
+
+func (l *UpstreamLimiter) Collect(ch chan<- prometheus.Metric) {
+	l.throttled.Collect(ch)
+	l.shed.Collect(ch)
+	l.queueLength.Collect(ch)
+	l.backoff.Collect(ch)
+}
+
+var _ http.RoundTripper = UpstreamTransport{}
+
+// UpstreamTransport is an http.RoundTripper that makes calls to TMDB subject to an UpstreamLimiter and a
+// CircuitBreaker. It allows calls made outside the proxy handler (e.g. by the ChangesPoller) to share the proxy's
+// rate limit and breaker. Calls that aren't allowed fail with a ThrottledError or a CircuitOpenError.
+type UpstreamTransport struct {
+	Upstream *UpstreamLimiter
+	// Breaker is optional.
+	Breaker *CircuitBreaker
+	// Next makes the actual call. Defaults to http.DefaultTransport.
+	Next http.RoundTripper
+}
+
+func (t UpstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.Breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	if err = t.Upstream.wait(req.Context()); err != nil {
+		done(0, 0, err)
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := cmp.Or[http.RoundTripper](t.Next, http.DefaultTransport).RoundTrip(req)
+	var statusCode int
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+	if statusCode == http.StatusTooManyRequests {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		retryAfter := t.Upstream.throttle(resp)
+		resp, err = nil, &ThrottledError{RetryAfter: retryAfter}
+	}
+	done(statusCode, time.Since(start), err)
+	return resp, err
+}
+
+func isThrottled(err error) (time.Duration, bool) {
+	var throttled *ThrottledError
+	if errors.As(err, &throttled) {
+		return throttled.RetryAfter, true
+	}
+	return 0, false
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30"))
+	assert.InDelta(t, time.Minute, parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)), float64(time.Second))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter(""))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("soon"))
+}
+
+func TestTMDBProxyHandler_UpstreamThrottled(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/3/movie/2" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+
+	upstream := NewUpstreamLimiter(0, 0, time.Second, "", "", nil)
+	backend := NewMemoryCache(0, 0)
+	h := TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Hour, Upstream: upstream}, discardLogger)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// tmdb throttles the proxy
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/2", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, int32(2), calls.Load())
+
+	// while throttled, misses are rejected without calling tmdb, but cached responses are still served
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/3", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/3/movie/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// health reports the throttling
+	w = httptest.NewRecorder()
+	HealthHandler(backend, upstream, nil, discardLogger).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"cache":"ok","upstream":{"throttled":true,"retry_after_seconds":30}}`, w.Body.String())
+}
+
+func TestUpstreamLimiter(t *testing.T) {
+	l := NewUpstreamLimiter(rate.Every(time.Minute), 1, 100*time.Millisecond, "tmdb", "proxy", nil)
+	assert.NoError(t, l.wait(t.Context()))
+
+	err := l.wait(t.Context())
+	retryAfter, ok := isThrottled(err)
+	assert.True(t, ok)
+	assert.Greater(t, retryAfter, 50*time.Second)
+	assert.Equal(t, 1, testutil.CollectAndCount(l, "tmdb_proxy_upstream_shed_total"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(l.shed))
+
+	// requests wait for their turn, up to the queue timeout
+	l = NewUpstreamLimiter(rate.Every(50*time.Millisecond), 1, 100*time.Millisecond, "", "", nil)
+	start := time.Now()
+	for range 3 {
+		assert.NoError(t, l.wait(t.Context()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
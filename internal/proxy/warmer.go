@@ -0,0 +1,341 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/time/rate"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Warmer prefetches responses through the proxy, so they are cached before clients ask for them (e.g. after the cache
+// was flushed). It prefetches:
+//   - the first Pages pages of each of the Lists (e.g. "/3/trending/movie/day", "/3/movie/popular")
+//   - the combined credits of the People most popular people, as listed in TMDB's daily person id export, and the
+//     credits of each of their movies
+//
+// Prefetches go through Handler, i.e. the proxy's TMDBProxyHandler, so responses are cached with the proxy's TTL
+// policy and requests that are already cached cost nothing. Prefetches that miss the cache are limited to Rate per
+// second.
+type Warmer struct {
+	Handler http.Handler
+	Lists   []string
+	// Pages is the number of pages of each list to prefetch. Defaults to 1.
+	Pages  int
+	People int
+	// ExportURL is the location of TMDB's daily id exports. Defaults to https://files.tmdb.org/p/exports.
+	ExportURL string
+	// ExportTimeout is the maximum time to download an export. Defaults to 5 minutes.
+	ExportTimeout time.Duration
+	// Rate limits the prefetches that miss the cache, per second. A zero Rate means no limit.
+	Rate     rate.Limit
+	Interval time.Duration
+	Logger   *slog.Logger
+
+	lock    sync.Mutex
+	running bool
+	last    WarmStats
+}
+
+// WarmStats reports the progress of a warm-up.
+type WarmStats struct {
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Requests int       `json:"requests"`
+	Misses   int       `json:"misses"`
+	Errors   int       `json:"errors"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// ErrWarming is returned by Warm if a warm-up is already running.
+var ErrWarming = errors.New("warm-up already running")
+
+// warmerClient is the name under which the warmer's requests are limited and measured.
+const warmerClient = "warmer"
+
+// Run warms the cache immediately and then every Interval, until the context is canceled. If Interval is zero, Run
+// warms the cache once.
+func (w *Warmer) Run(ctx context.Context) error {
+	for {
+		_, _ = w.Warm(ctx)
+		if w.Interval <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(w.Interval):
+		}
+	}
+}
+
+// Start warms the cache in the background. It returns false if a warm-up is already running.
+func (w *Warmer) Start(ctx context.Context) bool {
+	if !w.start() {
+		return false
+	}
+	go func() { _, _ = w.warm(ctx) }()
+	return true
+}
+
+// Warm prefetches the configured responses. It returns ErrWarming if a warm-up is already running.
+func (w *Warmer) Warm(ctx context.Context) (WarmStats, error) {
+	if !w.start() {
+		return w.Stats(), ErrWarming
+	}
+	return w.warm(ctx)
+}
+
+// Stats returns the progress of the running warm-up, or the result of the last one.
+func (w *Warmer) Stats() WarmStats {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.last
+}
+
+func (w *Warmer) start() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.running {
+		return false
+	}
+	w.running = true
+	w.last = WarmStats{Running: true, Started: time.Now()}
+	return true
+}
+
+func (w *Warmer) warm(ctx context.Context) (WarmStats, error) {
+	run := warmRun{warmer: w, limiter: newLimiter(w.Rate, 1)}
+	w.Logger.Info("warming cache", "lists", len(w.Lists), "people", w.People)
+	err := run.lists(ctx)
+	if err == nil && w.People > 0 {
+		err = run.people(ctx)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.running = false
+	w.last.Running = false
+	w.last.Finished = time.Now()
+	logger := w.Logger.With("requests", w.last.Requests, "misses", w.last.Misses, "errors", w.last.Errors,
+		"duration", w.last.Finished.Sub(w.last.Started))
+	if err != nil {
+		w.last.Error = err.Error()
+		logger.Warn("failed to warm cache", "err", err)
+	} else {
+		logger.Info("cache warmed")
+	}
+	return w.last, err
+}
+
+// warmRun performs a single warm-up.
+type warmRun struct {
+	warmer  *Warmer
+	limiter *rate.Limiter
+}
+
+func (r warmRun) lists(ctx context.Context) error {
+	for _, list := range r.warmer.Lists {
+		for page := 1; page <= max(1, r.warmer.Pages); page++ {
+			// a failed list doesn't stop the warm-up, but a canceled context does
+			if _, _ = r.get(ctx, list+"?page="+strconv.Itoa(page)); ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+func (r warmRun) people(ctx context.Context) error {
+	people, err := r.warmer.popularPeople(ctx)
+	if err != nil {
+		return fmt.Errorf("person export: %w", err)
+	}
+	movies := make(map[int]struct{})
+	for _, person := range people {
+		body, err := r.get(ctx, "/3/person/"+strconv.Itoa(person)+"/combined_credits")
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var credits struct {
+			Cast []struct {
+				Id        int    `json:"id"`
+				MediaType string `json:"media_type"`
+			} `json:"cast"`
+		}
+		if err != nil || json.Unmarshal(body, &credits) != nil {
+			continue
+		}
+		for _, credit := range credits.Cast {
+			if _, ok := movies[credit.Id]; ok || credit.MediaType != "movie" {
+				continue
+			}
+			movies[credit.Id] = struct{}{}
+			if _, _ = r.get(ctx, "/3/movie/"+strconv.Itoa(credit.Id)+"/credits"); ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// warmAttempts is the number of times a request that is rejected with 429 is sent, before it fails.
+const warmAttempts = 3
+
+// get sends the request through the proxy and returns the body of the response. If the proxy (or TMDB) rejects the
+// request with 429, get waits for the requested time and tries again, up to warmAttempts times.
+func (r warmRun) get(ctx context.Context, target string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.RemoteAddr = warmerClient
+		var resp bufferedResponse
+		r.warmer.Handler.ServeHTTP(&resp, req)
+
+		if resp.statusCode == http.StatusTooManyRequests && attempt < warmAttempts {
+			retryAfter := parseRetryAfter(resp.Header().Get("Retry-After"))
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(retryAfter):
+			}
+			continue
+		}
+
+		miss := resp.Header().Get("X-Cache") != "HIT"
+		r.warmer.record(miss, resp.statusCode != http.StatusOK)
+		if miss {
+			if err = r.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if resp.statusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", target, http.StatusText(resp.statusCode))
+		}
+		return resp.body.Bytes(), nil
+	}
+}
+
+func (w *Warmer) record(miss bool, failed bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.last.Requests++
+	if miss {
+		w.last.Misses++
+	}
+	if failed {
+		w.last.Errors++
+	}
+}
+
+// popularPeople returns the ids of the most popular people in TMDB's latest person id export. TMDB publishes the
+// export daily, around 8:00 UTC: if today's export isn't available yet, yesterday's is used.
+func (w *Warmer) popularPeople(ctx context.Context) ([]int, error) {
+	today := time.Now().UTC()
+	people, err := w.personExport(ctx, today)
+	if errors.Is(err, ErrNotFound) {
+		people, err = w.personExport(ctx, today.AddDate(0, 0, -1))
+	}
+	return people, err
+}
+
+func (w *Warmer) personExport(ctx context.Context, day time.Time) ([]int, error) {
+	target := cmp.Or(w.ExportURL, "https://files.tmdb.org/p/exports") + "/person_ids_" + day.Format("01_02_2006") + ".json.gz"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the timeout also covers reading the body: a stalled download would otherwise block all later warm-ups
+	client := http.Client{Timeout: cmp.Or(w.ExportTimeout, 5*time.Minute)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", target, ErrNotFound)
+	default:
+		return nil, fmt.Errorf("%s: %s", target, resp.Status)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return topPeople(zr, w.People)
+}
+
+// topPeople returns the ids of the n most popular people in a person id export, i.e. one JSON object per line. Adult
+// performers are skipped.
+func topPeople(r io.Reader, n int) ([]int, error) {
+	type person struct {
+		Adult      bool    `json:"adult"`
+		Id         int     `json:"id"`
+		Popularity float64 `json:"popularity"`
+	}
+	byPopularity := func(a, b person) int { return cmp.Compare(b.Popularity, a.Popularity) }
+	// the export holds millions of people: only keep the most popular ones seen so far
+	var people []person
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var p person
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil || p.Adult {
+			continue
+		}
+		if people = append(people, p); len(people) >= 2*n {
+			slices.SortFunc(people, byPopularity)
+			people = people[:n]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(people, byPopularity)
+	ids := make([]int, 0, n)
+	for _, p := range people[:min(n, len(people))] {
+		ids = append(ids, p.Id)
+	}
+	return ids, nil
+}
+
+var _ http.ResponseWriter = &bufferedResponse{}
+
+// bufferedResponse records the proxy's response to a prefetch.
+type bufferedResponse struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	if b.header == nil {
+		b.header = make(http.Header)
+	}
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(statusCode int) {
+	if b.statusCode == 0 {
+		b.statusCode = statusCode
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
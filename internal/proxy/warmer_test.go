@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmer(t *testing.T) {
+	var lock sync.Mutex
+	calls := make(map[string]int)
+	export := gzipped(t, `{"adult":false,"id":1,"name":"foo","popularity":1.5}
+{"adult":false,"id":2,"name":"bar","popularity":10.2}
+{"adult":true,"id":3,"name":"snafu","popularity":20}
+{"adult":false,"id":4,"name":"baz","popularity":0.1}
+`)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("01_02_2006")
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		calls[r.URL.RequestURI()]++
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/p/exports/person_ids_" + yesterday + ".json.gz":
+			_, _ = w.Write(export)
+		case "/3/trending/movie/day", "/3/movie/popular":
+			_, _ = w.Write([]byte(`{"page":1,"results":[]}`))
+		case "/3/person/2/combined_credits":
+			_, _ = w.Write([]byte(`{"cast":[{"id":10,"media_type":"movie"},{"id":11,"media_type":"tv"},{"id":12,"media_type":"movie"}]}`))
+		case "/3/person/1/combined_credits":
+			_, _ = w.Write([]byte(`{"cast":[{"id":10,"media_type":"movie"}]}`))
+		case "/3/movie/10/credits", "/3/movie/12/credits":
+			_, _ = w.Write([]byte(`{"cast":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	w := Warmer{
+		Handler:   TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Hour}, discardLogger),
+		Lists:     []string{"/3/trending/movie/day", "/3/movie/popular"},
+		Pages:     2,
+		People:    2,
+		ExportURL: s.URL + "/p/exports",
+		Logger:    discardLogger,
+	}
+	stats, err := w.Warm(t.Context())
+	require.NoError(t, err)
+	assert.False(t, stats.Running)
+	assert.Equal(t, 8, stats.Requests)
+	assert.Equal(t, 8, stats.Misses)
+	assert.Zero(t, stats.Errors)
+	lock.Lock()
+	assert.Equal(t, 1, calls["/3/movie/popular?page=2"])
+	// movie 10 is in the credits of both people, but is only prefetched once
+	assert.Equal(t, 1, calls["/3/movie/10/credits"])
+	assert.Zero(t, calls["/3/person/4/combined_credits"])
+	lock.Unlock()
+
+	// everything is cached now
+	stats, err = w.Warm(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 8, stats.Requests)
+	assert.Zero(t, stats.Misses)
+}
+
+func TestWarmer_ExportNotFound(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(s.Close)
+
+	w := Warmer{
+		Handler:   TMDBProxyHandler(NewMemoryCache(0, 0), Options{Target: s.URL, TTL: time.Hour}, discardLogger),
+		Lists:     []string{"/3/movie/popular"},
+		People:    10,
+		ExportURL: s.URL,
+		Logger:    discardLogger,
+	}
+	stats, err := w.Warm(t.Context())
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 1, stats.Requests)
+	assert.Equal(t, 1, stats.Errors)
+	assert.NotEmpty(t, w.Stats().Error)
+}
+
+func TestWarmer_ExportTimeout(t *testing.T) {
+	stalled := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(s.Close)
+	t.Cleanup(func() { close(stalled) })
+
+	w := Warmer{
+		Handler:       http.NotFoundHandler(),
+		People:        10,
+		ExportURL:     s.URL,
+		ExportTimeout: 100 * time.Millisecond,
+		Logger:        discardLogger,
+	}
+	_, err := w.Warm(t.Context())
+	assert.Error(t, err)
+	// the stalled download doesn't block the next warm-up
+	assert.False(t, w.Stats().Running)
+}
+
+func TestWarmer_Throttled(t *testing.T) {
+	var calls atomic.Int32
+	w := Warmer{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		}),
+		Lists:  []string{"/3/movie/popular"},
+		Logger: discardLogger,
+	}
+	// a request that stays throttled fails after warmAttempts, instead of blocking the warm-up
+	stats, err := w.Warm(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, int32(warmAttempts), calls.Load())
+	assert.Equal(t, 1, stats.Requests)
+	assert.Equal(t, 1, stats.Errors)
+}
+
+func TestAdminHandler_Warm_Canceled(t *testing.T) {
+	w := Warmer{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}),
+		Lists:  []string{"/3/movie/popular"},
+		Logger: discardLogger,
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	r := httptest.NewRequest(http.MethodPost, "/cache/warm", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	resp := httptest.NewRecorder()
+	AdminHandler(ctx, NewMemoryCache(0, 0), nil, &w, "secret", discardLogger).ServeHTTP(resp, r)
+	require.Equal(t, http.StatusAccepted, resp.Code)
+	assert.True(t, w.Stats().Running)
+
+	// stopping the server stops the warm-up
+	cancel()
+	assert.Eventually(t, func() bool { return !w.Stats().Running }, time.Second, 10*time.Millisecond)
+}
+
+func TestAdminHandler_Warm(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+
+	backend := NewMemoryCache(0, 0)
+	warmer := Warmer{
+		Handler: TMDBProxyHandler(backend, Options{Target: s.URL, TTL: time.Hour}, discardLogger),
+		Lists:   []string{"/3/movie/popular"},
+		Logger:  discardLogger,
+	}
+	admin := AdminHandler(t.Context(), backend, nil, &warmer, "secret", discardLogger)
+	do := func(method string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/cache/warm", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Eventually(t, func() bool {
+		w = do(http.MethodGet)
+		return w.Code == http.StatusOK && strings.Contains(w.Body.String(), `"running":false`)
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, w.Body.String(), `"requests":1`)
+
+	// the warm endpoints are unavailable if the proxy has no warmer
+	r := httptest.NewRequest(http.MethodPost, "/cache/warm", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	AdminHandler(t.Context(), backend, nil, nil, "secret", discardLogger).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestTopPeople(t *testing.T) {
+	export := `{"adult":false,"id":1,"popularity":1}
+{"adult":false,"id":2,"popularity":5}
+not json
+{"adult":false,"id":3,"popularity":3}
+{"adult":false,"id":4,"popularity":4}
+{"adult":false,"id":5,"popularity":2}
+`
+	people, err := topPeople(strings.NewReader(export), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4}, people)
+
+	people, err = topPeople(strings.NewReader(export), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 3, 5, 1}, people)
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
@@ -0,0 +1,32 @@
+package tmdb
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Change is an item (movie, person or tv show) that was edited on TMDB.
+type Change struct {
+	Id    int   `json:"id"`
+	Adult *bool `json:"adult"`
+}
+
+// Changes returns a Cursor over the ids of all items that changed between start and end. mediaType is "movie",
+// "person" or "tv". TMDB only keeps 14 days of changes, with a granularity of one day.
+func (c Client) Changes(mediaType string, start, end time.Time) *Cursor[Change] {
+	return newCursor(func(ctx context.Context, page int) (Page[Change], error) {
+		switch mediaType {
+		case "movie", "person", "tv":
+		default:
+			return Page[Change]{}, fmt.Errorf("invalid media type: %q", mediaType)
+		}
+		values := make(url.Values)
+		values.Set("start_date", start.Format(time.DateOnly))
+		values.Set("end_date", end.Format(time.DateOnly))
+		values.Set("page", strconv.Itoa(page))
+		return call[Page[Change]](ctx, c, route("/3/"+mediaType+"/changes"), values)
+	})
+}
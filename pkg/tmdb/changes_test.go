@@ -0,0 +1,37 @@
+package tmdb_test
+
+import (
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClient_Changes(t *testing.T) {
+	s := makeTestServer("GET /3/{mediaType}/changes", func(r *http.Request) string {
+		if r.FormValue("start_date") != "2024-10-01" || r.FormValue("end_date") != "2024-10-02" {
+			return "invalid"
+		}
+		return "get-" + r.PathValue("mediaType") + "-changes-" + r.FormValue("page") + ".json"
+	})
+	t.Cleanup(s.Close)
+	c := tmdb.New("", nil)
+	c.BaseURL = s.URL
+
+	start := time.Date(2024, time.October, 1, 12, 0, 0, 0, time.UTC)
+	changes, err := c.Changes("movie", start, start.Add(24*time.Hour)).All(context.Background())
+	require.NoError(t, err)
+	ids := make([]int, len(changes))
+	for i, change := range changes {
+		ids[i] = change.Id
+	}
+	assert.Equal(t, []int{1290417, 680, 550, 13}, ids)
+
+	_, err = c.Changes("movie", start, start).All(context.Background())
+	assert.Error(t, err)
+	_, err = c.Changes("collection", start, start).All(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package tmdb
+
+import (
+	"context"
+)
+
+type Language struct {
+	Iso6391     string `json:"iso_639_1"`
+	EnglishName string `json:"english_name"`
+	Name        string `json:"name"`
+}
+
+// GetLanguages returns all languages used by TMDB. The result is cached for the lifetime of the client.
+func (c Client) GetLanguages(ctx context.Context) ([]Language, error) {
+	return cachedCall[[]Language](ctx, c, route("/3/configuration/languages"), nil)
+}
+
+type Country struct {
+	Iso31661    string `json:"iso_3166_1"`
+	EnglishName string `json:"english_name"`
+	NativeName  string `json:"native_name"`
+}
+
+// GetCountries returns all countries used by TMDB. The result is cached for the lifetime of the client.
+func (c Client) GetCountries(ctx context.Context) ([]Country, error) {
+	return cachedCall[[]Country](ctx, c, route("/3/configuration/countries"), nil)
+}
+
+type Department struct {
+	Department string   `json:"department"`
+	Jobs       []string `json:"jobs"`
+}
+
+// GetJobs returns all departments and their jobs, as found in e.g. CrewCredit. The result is cached for the lifetime of the client.
+func (c Client) GetJobs(ctx context.Context) ([]Department, error) {
+	return cachedCall[[]Department](ctx, c, route("/3/configuration/jobs"), nil)
+}
+
+type Timezones struct {
+	Iso31661 string   `json:"iso_3166_1"`
+	Zones    []string `json:"zones"`
+}
+
+// GetTimezones returns the timezones of each country. The result is cached for the lifetime of the client.
+func (c Client) GetTimezones(ctx context.Context) ([]Timezones, error) {
+	return cachedCall[[]Timezones](ctx, c, route("/3/configuration/timezones"), nil)
+}
+
+// GetPrimaryTranslations returns the languages (e.g. "en-US") that TMDB considers primary translations. The result is
+// cached for the lifetime of the client.
+func (c Client) GetPrimaryTranslations(ctx context.Context) ([]string, error) {
+	return cachedCall[[]string](ctx, c, route("/3/configuration/primary_translations"), nil)
+}
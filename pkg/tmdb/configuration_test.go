@@ -0,0 +1,46 @@
+package tmdb_test
+
+import (
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_Configuration(t *testing.T) {
+	var calls atomic.Int32
+	s := makeTestServer("GET /3/configuration/{item}", func(r *http.Request) string {
+		calls.Add(1)
+		return "get-configuration-" + r.PathValue("item") + ".json"
+	})
+	t.Cleanup(s.Close)
+	c := tmdb.New("", nil)
+	c.BaseURL = s.URL
+	ctx := context.Background()
+
+	for range 2 {
+		languages, err := c.GetLanguages(ctx)
+		require.NoError(t, err)
+		assert.Len(t, languages, 3)
+
+		countries, err := c.GetCountries(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "Belgium", countries[0].EnglishName)
+
+		jobs, err := c.GetJobs(ctx)
+		require.NoError(t, err)
+		assert.Contains(t, jobs[0].Jobs, "Director")
+
+		timezones, err := c.GetTimezones(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Europe/Brussels"}, timezones[0].Zones)
+
+		translations, err := c.GetPrimaryTranslations(ctx)
+		require.NoError(t, err)
+		assert.Contains(t, translations, "nl-BE")
+	}
+	assert.Equal(t, int32(5), calls.Load())
+}
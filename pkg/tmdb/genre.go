@@ -0,0 +1,52 @@
+package tmdb
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrGenreNotFound = errors.New("genre not found")
+
+type Genre struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type genres struct {
+	Genres []Genre `json:"genres"`
+}
+
+// GetMovieGenres returns all movie genres. The result is cached for the lifetime of the client.
+func (c Client) GetMovieGenres(ctx context.Context) ([]Genre, error) {
+	result, err := cachedCall[genres](ctx, c, route("/3/genre/movie/list"), nil)
+	return result.Genres, err
+}
+
+// GetTVGenres returns all tv genres. The result is cached for the lifetime of the client.
+func (c Client) GetTVGenres(ctx context.Context) ([]Genre, error) {
+	result, err := cachedCall[genres](ctx, c, route("/3/genre/tv/list"), nil)
+	return result.Genres, err
+}
+
+// GenreName returns the name of a genre, as found in e.g. CastCredit.GenreIds. mediaType is either "movie" or "tv".
+func (c Client) GenreName(ctx context.Context, id int, mediaType string) (string, error) {
+	var list []Genre
+	var err error
+	switch mediaType {
+	case "movie":
+		list, err = c.GetMovieGenres(ctx)
+	case "tv":
+		list, err = c.GetTVGenres(ctx)
+	default:
+		return "", errors.New("invalid media type: " + mediaType)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, genre := range list {
+		if genre.Id == id {
+			return genre.Name, nil
+		}
+	}
+	return "", ErrGenreNotFound
+}
@@ -0,0 +1,54 @@
+package tmdb_test
+
+import (
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_GenreName(t *testing.T) {
+	var calls atomic.Int32
+	s := makeTestServer("GET /3/genre/{mediaType}/list", func(r *http.Request) string {
+		calls.Add(1)
+		return "get-genre-" + r.PathValue("mediaType") + "-list.json"
+	})
+	c := tmdb.New("", nil)
+	c.BaseURL = s.URL
+
+	tests := []struct {
+		name      string
+		id        int
+		mediaType string
+		want      string
+		wantErr   assert.ErrorAssertionFunc
+	}{
+		{name: "movie", id: 878, mediaType: "movie", want: "Science Fiction", wantErr: assert.NoError},
+		{name: "tv", id: 10765, mediaType: "tv", want: "Sci-Fi & Fantasy", wantErr: assert.NoError},
+		{name: "not found", id: 10765, mediaType: "movie", wantErr: assert.Error},
+		{name: "invalid media type", id: 1, mediaType: "person", wantErr: assert.Error},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := c.GenreName(ctx, tt.id, tt.mediaType)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, name)
+		})
+	}
+	// genres are only retrieved once per media type
+	assert.Equal(t, int32(2), calls.Load())
+
+	// cached results survive the server going away
+	s.Close()
+	_, err := c.GetMovieGenres(ctx)
+	require.NoError(t, err)
+
+	// but a different language is a different result
+	c.Language = "fr-FR"
+	_, err = c.GetMovieGenres(ctx)
+	assert.Error(t, err)
+}
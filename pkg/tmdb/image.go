@@ -0,0 +1,95 @@
+package tmdb
+
+import (
+	"cmp"
+	"context"
+	"net/url"
+	"strings"
+)
+
+type Image struct {
+	AspectRatio float64 `json:"aspect_ratio"`
+	FilePath    string  `json:"file_path"`
+	Height      int     `json:"height"`
+	Iso6391     *string `json:"iso_639_1"`
+	VoteAverage float64 `json:"vote_average"`
+	VoteCount   int     `json:"vote_count"`
+	Width       int     `json:"width"`
+}
+
+type Images struct {
+	Id        int     `json:"id"`
+	Backdrops []Image `json:"backdrops"`
+	Logos     []Image `json:"logos"`
+	Posters   []Image `json:"posters"`
+}
+
+type PersonImages struct {
+	Id       int     `json:"id"`
+	Profiles []Image `json:"profiles"`
+}
+
+func (c Client) GetMovieImages(ctx context.Context, id int) (Images, error) {
+	return call[Images](ctx, c, route("/3/movie/{id}/images", id), c.imageForm())
+}
+
+func (c Client) GetTVImages(ctx context.Context, id int) (Images, error) {
+	return call[Images](ctx, c, route("/3/tv/{id}/images", id), c.imageForm())
+}
+
+func (c Client) GetCollectionImages(ctx context.Context, id int) (Images, error) {
+	return call[Images](ctx, c, route("/3/collection/{id}/images", id), c.imageForm())
+}
+
+func (c Client) GetPersonImages(ctx context.Context, id int) (PersonImages, error) {
+	return call[PersonImages](ctx, c, route("/3/person/{id}/images", id), nil)
+}
+
+// imageForm asks TMDB to include images without a language, next to the ones in the client's language.
+// Otherwise, TMDB only returns images in the client's language.
+func (c Client) imageForm() url.Values {
+	return url.Values{"include_image_language": []string{imageLanguage(c.Language) + ",null"}}
+}
+
+// ImageURL returns the URL of an image's file path at the requested size (e.g. "w500", or "original").
+func (c Client) ImageURL(size string, filePath string) string {
+	return c.ImageBaseURL + "/" + size + filePath
+}
+
+// BestImage returns the best image for the language (e.g. "en" or "en-US"). It prefers images in that language,
+// then images without a language (which typically contain no text) and then any other image. Amongst those,
+// the image with the highest rating wins.  BestImage returns false if images is empty.
+func BestImage(images []Image, language string) (Image, bool) {
+	language = imageLanguage(language)
+	rank := func(img Image) int {
+		switch {
+		case img.Iso6391 != nil && *img.Iso6391 == language:
+			return 2
+		case img.Iso6391 == nil || *img.Iso6391 == "":
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	var best Image
+	var found bool
+	for _, img := range images {
+		if !found || cmp.Or(
+			cmp.Compare(rank(img), rank(best)),
+			cmp.Compare(img.VoteAverage, best.VoteAverage),
+			cmp.Compare(img.VoteCount, best.VoteCount),
+			cmp.Compare(img.Width, best.Width),
+		) > 0 {
+			best = img
+			found = true
+		}
+	}
+	return best, found
+}
+
+// imageLanguage returns the ISO 639-1 part of a language (e.g. "en" for "en-US"), as used for images.
+func imageLanguage(language string) string {
+	lang, _, _ := strings.Cut(language, "-")
+	return lang
+}
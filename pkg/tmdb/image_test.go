@@ -0,0 +1,112 @@
+package tmdb_test
+
+import (
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestClient_GetMovieImages(t *testing.T) {
+	s := makeTestServer("GET /3/movie/{id}/images", func(r *http.Request) string {
+		if r.FormValue("include_image_language") != "en,null" {
+			return "invalid"
+		}
+		return "get-movie-images-" + r.PathValue("id") + ".json"
+	})
+	t.Cleanup(s.Close)
+	c := tmdb.New("", nil)
+	c.BaseURL = s.URL
+
+	images, err := c.GetMovieImages(context.Background(), 680)
+	require.NoError(t, err)
+	assert.Len(t, images.Backdrops, 2)
+	assert.Len(t, images.Logos, 1)
+	assert.Len(t, images.Posters, 2)
+
+	poster, ok := tmdb.BestImage(images.Posters, c.Language)
+	require.True(t, ok)
+	assert.Equal(t, "https://image.tmdb.org/t/p/w500/d5iIlFn5s0ImszYzBPb8JPIfbXD.jpg", c.ImageURL("w500", poster.FilePath))
+}
+
+func TestClient_GetPersonImages(t *testing.T) {
+	s := makeTestServer("GET /3/person/{id}/images", func(r *http.Request) string {
+		return "get-person-images-" + r.PathValue("id") + ".json"
+	})
+	t.Cleanup(s.Close)
+	c := tmdb.New("", nil)
+	c.BaseURL = s.URL
+
+	images, err := c.GetPersonImages(context.Background(), 31)
+	require.NoError(t, err)
+	profile, ok := tmdb.BestImage(images.Profiles, c.Language)
+	require.True(t, ok)
+	assert.Equal(t, "/xndWFsBlClOJFRdhSt4NBwiPq2o.jpg", profile.FilePath)
+}
+
+func TestBestImage(t *testing.T) {
+	en, fr, none := "en", "fr", ""
+	tests := []struct {
+		name     string
+		images   []tmdb.Image
+		language string
+		want     string
+		wantOK   bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "language wins",
+			images: []tmdb.Image{
+				{FilePath: "/none.jpg", VoteAverage: 10},
+				{FilePath: "/fr.jpg", Iso6391: &fr, VoteAverage: 10},
+				{FilePath: "/en.jpg", Iso6391: &en, VoteAverage: 1},
+			},
+			language: "en-US",
+			want:     "/en.jpg",
+			wantOK:   true,
+		},
+		{
+			name: "fall back to language-less images",
+			images: []tmdb.Image{
+				{FilePath: "/fr.jpg", Iso6391: &fr, VoteAverage: 10},
+				{FilePath: "/none.jpg", VoteAverage: 1},
+				{FilePath: "/empty.jpg", Iso6391: &none, VoteAverage: 2},
+			},
+			language: "en",
+			want:     "/empty.jpg",
+			wantOK:   true,
+		},
+		{
+			name: "fall back to any image",
+			images: []tmdb.Image{
+				{FilePath: "/fr.jpg", Iso6391: &fr, VoteAverage: 1},
+			},
+			language: "en",
+			want:     "/fr.jpg",
+			wantOK:   true,
+		},
+		{
+			name: "votes, then size",
+			images: []tmdb.Image{
+				{FilePath: "/small.jpg", Iso6391: &en, VoteAverage: 5, VoteCount: 10, Width: 500},
+				{FilePath: "/large.jpg", Iso6391: &en, VoteAverage: 5, VoteCount: 10, Width: 1000},
+				{FilePath: "/few-votes.jpg", Iso6391: &en, VoteAverage: 5, VoteCount: 1, Width: 2000},
+			},
+			language: "en",
+			want:     "/large.jpg",
+			wantOK:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			img, ok := tmdb.BestImage(tt.images, tt.language)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, img.FilePath)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package tmdb
+
+import (
+	"context"
+	"time"
+)
+
+// Instrumentation receives an event at the start and at the end of each API call made by a Client. Results served
+// from the client's reference data cache don't call TMDB: they are reported with CacheHit set.
+// See tmdbprom and tmdbotel for Prometheus and OpenTelemetry implementations.
+type Instrumentation interface {
+	// RequestStarted is called before the request is sent. The returned context is used for the request and passed
+	// to RequestFinished, e.g. to propagate a trace span.
+	RequestStarted(ctx context.Context, request RequestInfo) context.Context
+	// RequestFinished is called once the response has been processed.
+	RequestFinished(ctx context.Context, request RequestInfo, result RequestResult)
+}
+
+type RequestInfo struct {
+	Method string
+	// Endpoint is the template of the request's path, e.g. "/3/movie/{id}".
+	Endpoint string
+	URL      string
+}
+
+type RequestResult struct {
+	// StatusCode is the HTTP status code of the response. It is zero if no response was received.
+	StatusCode int
+	Duration   time.Duration
+	// Bytes is the size of the response body.
+	Bytes int64
+	// CacheHit is true if the result was served from the client's reference data cache, without calling TMDB.
+	// Implementations should report cache hits separately from the calls to TMDB.
+	CacheHit bool
+	Err      error
+}
+
+func (c Client) instrument(ctx context.Context, request RequestInfo) (context.Context, func(RequestResult)) {
+	if c.Instrumentation == nil {
+		return ctx, func(RequestResult) {}
+	}
+	start := time.Now()
+	ctx = c.Instrumentation.RequestStarted(ctx, request)
+	return ctx, func(result RequestResult) {
+		result.Duration = time.Since(start)
+		c.Instrumentation.RequestFinished(ctx, request, result)
+	}
+}
@@ -0,0 +1,192 @@
+package tmdb
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+type List struct {
+	AverageRating float64           `json:"average_rating"`
+	BackdropPath  *string           `json:"backdrop_path"`
+	Comments      map[string]string `json:"comments"`
+	CreatedBy     struct {
+		AvatarPath *string `json:"avatar_path"`
+		GravatarId string  `json:"gravatar_hash"`
+		Id         string  `json:"id"`
+		Name       string  `json:"name"`
+		Username   string  `json:"username"`
+	} `json:"created_by"`
+	Description  string      `json:"description"`
+	Id           int         `json:"id"`
+	Iso31661     string      `json:"iso_3166_1"`
+	Iso6391      string      `json:"iso_639_1"`
+	ItemCount    int         `json:"item_count"`
+	Name         string      `json:"name"`
+	Page         int         `json:"page"`
+	PosterPath   *string     `json:"poster_path"`
+	Public       bool        `json:"public"`
+	Results      []ListEntry `json:"results"`
+	Revenue      int64       `json:"revenue"`
+	Runtime      int         `json:"runtime"`
+	SortBy       string      `json:"sort_by"`
+	TotalPages   int         `json:"total_pages"`
+	TotalResults int         `json:"total_results"`
+}
+
+// Comment returns the comment the list's owner added to the item.
+func (l List) Comment(mediaType string, mediaId int) string {
+	return l.Comments[mediaType+":"+strconv.Itoa(mediaId)]
+}
+
+// ListEntry is a movie or tv show, as returned by v4 list and account endpoints.
+type ListEntry struct {
+	Adult            bool     `json:"adult"`
+	BackdropPath     *string  `json:"backdrop_path"`
+	GenreIds         []int    `json:"genre_ids"`
+	Id               int      `json:"id"`
+	MediaType        string   `json:"media_type"`
+	OriginalLanguage string   `json:"original_language"`
+	OriginalTitle    string   `json:"original_title,omitempty"`
+	Overview         string   `json:"overview"`
+	Popularity       float64  `json:"popularity"`
+	PosterPath       *string  `json:"poster_path"`
+	ReleaseDate      string   `json:"release_date,omitempty"`
+	Title            string   `json:"title,omitempty"`
+	Video            bool     `json:"video,omitempty"`
+	VoteAverage      float64  `json:"vote_average"`
+	VoteCount        int      `json:"vote_count"`
+	OriginCountry    []string `json:"origin_country,omitempty"`
+	OriginalName     string   `json:"original_name,omitempty"`
+	FirstAirDate     string   `json:"first_air_date,omitempty"`
+	Name             string   `json:"name,omitempty"`
+}
+
+// GetTitle returns the title of a movie, or the name of a tv show.
+func (e ListEntry) GetTitle() string {
+	if e.Title != "" {
+		return e.Title
+	}
+	return e.Name
+}
+
+// GetList returns one page of a list. Private lists require an access token.
+func (c V4Client) GetList(ctx context.Context, id int, page int) (List, error) {
+	return do[List](ctx, c.client, http.MethodGet, route("/4/list/{id}", id), c.form(page), c.accessToken, nil)
+}
+
+type ListOptions struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Iso6391     string `json:"iso_639_1,omitempty"`
+	Iso31661    string `json:"iso_3166_1,omitempty"`
+	Public      *bool  `json:"public,omitempty"`
+	SortBy      string `json:"sort_by,omitempty"`
+}
+
+// CreateList creates a new list for the user and returns its id.
+func (c V4Client) CreateList(ctx context.Context, options ListOptions) (int, error) {
+	resp, err := do[struct {
+		Id int `json:"id"`
+		v4Status
+	}](ctx, c.client, http.MethodPost, route("/4/list"), nil, c.accessToken, options)
+	return resp.Id, err
+}
+
+// UpdateList updates the list's details. Only the non-empty fields of options are changed.
+func (c V4Client) UpdateList(ctx context.Context, id int, options ListOptions) error {
+	_, err := do[v4Status](ctx, c.client, http.MethodPut, route("/4/list/{id}", id), nil, c.accessToken, options)
+	return err
+}
+
+// ClearList removes all items from the list.
+func (c V4Client) ClearList(ctx context.Context, id int) error {
+	_, err := do[v4Status](ctx, c.client, http.MethodGet, route("/4/list/{id}/clear", id), nil, c.accessToken, nil)
+	return err
+}
+
+// DeleteList deletes the list.
+func (c V4Client) DeleteList(ctx context.Context, id int) error {
+	_, err := do[v4Status](ctx, c.client, http.MethodDelete, route("/4/list/{id}", id), nil, c.accessToken, nil)
+	return err
+}
+
+type ListItem struct {
+	MediaType string `json:"media_type"`
+	MediaId   int    `json:"media_id"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+type ListItemResult struct {
+	MediaType string `json:"media_type"`
+	MediaId   int    `json:"media_id"`
+	Success   bool   `json:"success"`
+}
+
+// AddListItems adds movies or tv shows to the list. The result reports, for each item, whether it was added.
+func (c V4Client) AddListItems(ctx context.Context, id int, items ...ListItem) ([]ListItemResult, error) {
+	return c.listItems(ctx, http.MethodPost, id, items)
+}
+
+// UpdateListItems updates the comments of items already in the list.
+func (c V4Client) UpdateListItems(ctx context.Context, id int, items ...ListItem) ([]ListItemResult, error) {
+	return c.listItems(ctx, http.MethodPut, id, items)
+}
+
+// RemoveListItems removes movies or tv shows from the list.
+func (c V4Client) RemoveListItems(ctx context.Context, id int, items ...ListItem) ([]ListItemResult, error) {
+	return c.listItems(ctx, http.MethodDelete, id, items)
+}
+
+func (c V4Client) listItems(ctx context.Context, method string, id int, items []ListItem) ([]ListItemResult, error) {
+	body := struct {
+		Items []ListItem `json:"items"`
+	}{Items: items}
+	resp, err := do[struct {
+		Results []ListItemResult `json:"results"`
+		v4Status
+	}](ctx, c.client, method, route("/4/list/{id}/items", id), nil, c.accessToken, body)
+	return resp.Results, err
+}
+
+type AccountList struct {
+	AccountObjectId string  `json:"account_object_id"`
+	Adult           int     `json:"adult"`
+	AverageRating   float64 `json:"average_rating"`
+	BackdropPath    *string `json:"backdrop_path"`
+	CreatedAt       string  `json:"created_at"`
+	Description     string  `json:"description"`
+	Featured        int     `json:"featured"`
+	Id              int     `json:"id"`
+	Iso31661        string  `json:"iso_3166_1"`
+	Iso6391         string  `json:"iso_639_1"`
+	Name            string  `json:"name"`
+	NumberOfItems   int     `json:"number_of_items"`
+	PosterPath      *string `json:"poster_path"`
+	Public          int     `json:"public"`
+	Revenue         int64   `json:"revenue"`
+	Runtime         string  `json:"runtime"`
+	SortBy          int     `json:"sort_by"`
+	UpdatedAt       string  `json:"updated_at"`
+}
+
+// AccountLists returns a Cursor over all lists created by the account.
+func (c V4Client) AccountLists(accountId string) *Cursor[AccountList] {
+	return accountCursor[AccountList](c, accountId, "/lists")
+}
+
+// AccountWatchlist returns a Cursor over the account's watchlist. mediaType is either "movie" or "tv".
+func (c V4Client) AccountWatchlist(accountId string, mediaType string) *Cursor[ListEntry] {
+	return accountCursor[ListEntry](c, accountId, "/"+mediaType+"/watchlist")
+}
+
+// AccountRecommendations returns a Cursor over TMDB's recommendations for the account. mediaType is either "movie" or "tv".
+func (c V4Client) AccountRecommendations(accountId string, mediaType string) *Cursor[ListEntry] {
+	return accountCursor[ListEntry](c, accountId, "/"+mediaType+"/recommendations")
+}
+
+func accountCursor[T any](c V4Client, accountId string, suffix string) *Cursor[T] {
+	return newCursor(func(ctx context.Context, page int) (Page[T], error) {
+		return do[Page[T]](ctx, c.client, http.MethodGet, route("/4/account/{account_id}"+suffix, accountId), c.form(page), c.accessToken, nil)
+	})
+}
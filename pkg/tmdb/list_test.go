@@ -0,0 +1,113 @@
+package tmdb_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestV4Client_Lists(t *testing.T) {
+	type item struct {
+		mediaType string
+		comment   string
+	}
+	lists := make(map[string]map[int]item)
+
+	m := http.NewServeMux()
+	m.HandleFunc("POST /4/list", func(w http.ResponseWriter, r *http.Request) {
+		var options tmdb.ListOptions
+		if err := json.NewDecoder(r.Body).Decode(&options); err != nil || options.Name == "" {
+			http.Error(w, "invalid list", http.StatusUnprocessableEntity)
+			return
+		}
+		lists["1"] = make(map[int]item)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"success":true,"status_code":1,"id":1}`))
+	})
+	m.HandleFunc("GET /4/list/{id}", func(w http.ResponseWriter, r *http.Request) {
+		items, ok := lists[r.PathValue("id")]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		list := tmdb.List{Id: 1, Name: "list", Page: 1, TotalPages: 1, Comments: make(map[string]string)}
+		for id, i := range items {
+			list.Results = append(list.Results, tmdb.ListEntry{Id: id, MediaType: i.mediaType})
+			list.Comments[i.mediaType+":"+strconv.Itoa(id)] = i.comment
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	})
+	m.HandleFunc("PUT /4/list/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success":true,"status_code":12}`))
+	})
+	m.HandleFunc("GET /4/list/{id}/clear", func(w http.ResponseWriter, r *http.Request) {
+		lists[r.PathValue("id")] = make(map[int]item)
+		_, _ = w.Write([]byte(`{"success":true,"status_code":12}`))
+	})
+	m.HandleFunc("DELETE /4/list/{id}", func(w http.ResponseWriter, r *http.Request) {
+		delete(lists, r.PathValue("id"))
+		_, _ = w.Write([]byte(`{"success":true,"status_code":13}`))
+	})
+	m.HandleFunc("/4/list/{id}/items", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Items []tmdb.ListItem `json:"items"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		var resp struct {
+			Results []tmdb.ListItemResult `json:"results"`
+		}
+		for _, i := range body.Items {
+			switch r.Method {
+			case http.MethodDelete:
+				delete(lists[r.PathValue("id")], i.MediaId)
+			default:
+				lists[r.PathValue("id")][i.MediaId] = item{mediaType: i.MediaType, comment: i.Comment}
+			}
+			resp.Results = append(resp.Results, tmdb.ListItemResult{MediaType: i.MediaType, MediaId: i.MediaId, Success: true})
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	c := tmdb.New("", nil)
+	c.BaseURL = s.URL
+	v4 := c.V4().WithAccessToken("access-token")
+	ctx := context.Background()
+
+	_, err := v4.CreateList(ctx, tmdb.ListOptions{})
+	assert.Error(t, err)
+
+	id, err := v4.CreateList(ctx, tmdb.ListOptions{Name: "list", Iso6391: "en"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+	require.NoError(t, v4.UpdateList(ctx, id, tmdb.ListOptions{Description: "my list"}))
+
+	results, err := v4.AddListItems(ctx, id, tmdb.ListItem{MediaType: "movie", MediaId: 1}, tmdb.ListItem{MediaType: "tv", MediaId: 2})
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	_, err = v4.UpdateListItems(ctx, id, tmdb.ListItem{MediaType: "movie", MediaId: 1, Comment: "great"})
+	require.NoError(t, err)
+	_, err = v4.RemoveListItems(ctx, id, tmdb.ListItem{MediaType: "tv", MediaId: 2})
+	require.NoError(t, err)
+
+	list, err := v4.GetList(ctx, id, 1)
+	require.NoError(t, err)
+	require.Len(t, list.Results, 1)
+	assert.Equal(t, "great", list.Comment("movie", 1))
+
+	require.NoError(t, v4.ClearList(ctx, id))
+	list, err = v4.GetList(ctx, id, 1)
+	require.NoError(t, err)
+	assert.Empty(t, list.Results)
+
+	require.NoError(t, v4.DeleteList(ctx, id))
+	_, err = v4.GetList(ctx, id, 1)
+	assert.Error(t, err)
+}
@@ -3,7 +3,6 @@ package tmdb
 import (
 	"context"
 	"net/url"
-	"strconv"
 )
 
 type Movie struct {
@@ -15,11 +14,8 @@ type Movie struct {
 		PosterPath   string `json:"poster_path"`
 		BackdropPath string `json:"backdrop_path"`
 	} `json:"belongs_to_collection"`
-	Budget int `json:"budget"`
-	Genres []struct {
-		Id   int    `json:"id"`
-		Name string `json:"name"`
-	} `json:"genres"`
+	Budget              int         `json:"budget"`
+	Genres              []Genre     `json:"genres"`
 	Homepage            string      `json:"homepage"`
 	Id                  int         `json:"id"`
 	ImdbId              interface{} `json:"imdb_id"`
@@ -55,7 +51,7 @@ type Movie struct {
 }
 
 func (c Client) GetMovie(ctx context.Context, id int) (Movie, error) {
-	return call[Movie](ctx, c, c.BaseURL+"/3/movie/"+strconv.Itoa(id), url.Values{})
+	return call[Movie](ctx, c, route("/3/movie/{id}", id), url.Values{})
 }
 
 type MovieCredits struct {
@@ -65,7 +61,7 @@ type MovieCredits struct {
 }
 
 func (c Client) GetMovieCredits(ctx context.Context, id int) (MovieCredits, error) {
-	return call[MovieCredits](ctx, c, c.BaseURL+"/3/movie/"+strconv.Itoa(id)+"/credits", url.Values{})
+	return call[MovieCredits](ctx, c, route("/3/movie/{id}/credits", id), url.Values{})
 }
 
 type MovieCastCredits struct {
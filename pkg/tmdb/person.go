@@ -52,7 +52,7 @@ func (c Client) SearchPersonPage(ctx context.Context, query string, page int) ([
 		"page":  []string{strconv.Itoa(page)},
 	}
 
-	result, err := call[PersonsPage](ctx, c, c.BaseURL+"/3/search/person", values)
+	result, err := call[PersonsPage](ctx, c, route("/3/search/person"), values)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -77,7 +77,7 @@ func (c Client) SearchPersonAllPages(ctx context.Context, query string) ([]Perso
 }
 
 func (c Client) GetPerson(ctx context.Context, id int) (Person, error) {
-	return call[Person](ctx, c, c.BaseURL+"/3/person/"+strconv.Itoa(id), url.Values{})
+	return call[Person](ctx, c, route("/3/person/{id}", id), url.Values{})
 }
 
 type PersonCredits struct {
@@ -87,7 +87,7 @@ type PersonCredits struct {
 }
 
 func (c Client) GetPersonCredits(ctx context.Context, id int) (PersonCredits, error) {
-	return call[PersonCredits](ctx, c, c.BaseURL+"/3/person/"+strconv.Itoa(id)+"/combined_credits", nil)
+	return call[PersonCredits](ctx, c, route("/3/person/{id}/combined_credits", id), nil)
 }
 
 type CastCredit struct {
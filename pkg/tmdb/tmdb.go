@@ -1,6 +1,7 @@
 package tmdb
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,13 +9,19 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 )
 
 type Client struct {
 	IncludeAdult string
 	Language     string
 	BaseURL      string
-	httpClient   *http.Client
+	ImageBaseURL string
+	// Instrumentation, if set, is notified of every API call made by the client.
+	Instrumentation Instrumentation
+	httpClient      *http.Client
+	cache           *referenceCache
 }
 
 func New(authKey string, httpClient *http.Client) *Client {
@@ -32,7 +39,9 @@ func New(authKey string, httpClient *http.Client) *Client {
 		IncludeAdult: "false",
 		Language:     "en-US",
 		BaseURL:      "https://api.themoviedb.org",
+		ImageBaseURL: "https://image.tmdb.org/t/p",
 		httpClient:   httpClient,
+		cache:        &referenceCache{values: make(map[string]any)},
 	}
 }
 
@@ -51,35 +60,134 @@ type auth struct {
 }
 
 func (a auth) RoundTrip(r *http.Request) (*http.Response, error) {
-	r.Header.Set("Authorization", "Bearer "+a.authKey)
+	// requests made on behalf of a user (v4 access tokens) carry their own credentials
+	if r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+a.authKey)
+	}
 	return a.next.RoundTrip(r)
 }
 
-func call[T any](ctx context.Context, c Client, url string, values url.Values) (T, error) {
+// endpoint is the path of an API call, along with the template it was generated from (e.g. "/3/movie/{id}").
+type endpoint struct {
+	template string
+	path     string
+}
+
+// route returns the endpoint for a path template, replacing each parameter in the template with the next argument.
+func route(template string, args ...any) endpoint {
+	var path strings.Builder
+	rest := template
+	for _, arg := range args {
+		start := strings.IndexByte(rest, '{')
+		end := strings.IndexByte(rest, '}')
+		if start < 0 || end < start {
+			break
+		}
+		path.WriteString(rest[:start])
+		path.WriteString(url.PathEscape(fmt.Sprint(arg)))
+		rest = rest[end+1:]
+	}
+	path.WriteString(rest)
+	return endpoint{template: template, path: path.String()}
+}
+
+func call[T any](ctx context.Context, c Client, ep endpoint, values url.Values) (T, error) {
 	form := c.baseForm()
 	for key, v := range values {
 		for _, value := range v {
 			form.Add(key, value)
 		}
 	}
+	return do[T](ctx, c, http.MethodGet, ep, form, "", nil)
+}
+
+func do[T any](ctx context.Context, c Client, method string, ep endpoint, form url.Values, accessToken string, body any) (T, error) {
+	var result T
+	target := c.BaseURL + ep.path
+	if len(form) > 0 {
+		target += "?" + form.Encode()
+	}
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return result, fmt.Errorf("encode: %w", err)
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+
+	ctx, done := c.instrument(ctx, RequestInfo{Method: method, Endpoint: ep.template, URL: target})
+	var stats RequestResult
 
-	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+"?"+form.Encode(), nil)
+	req, _ := http.NewRequestWithContext(ctx, method, target, reqBody)
 	req.Header.Add("accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
 
-	var result T
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		stats.Err = err
+		done(stats)
 		return result, err
 	}
 	defer func(Body io.ReadCloser) { _ = Body.Close() }(resp.Body)
+	stats.StatusCode = resp.StatusCode
 
-	if resp.StatusCode != http.StatusOK {
-		return result, errors.New(resp.Status)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		stats.Err = errors.New(resp.Status)
+		done(stats)
+		return result, stats.Err
 	}
 
-	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return result, fmt.Errorf("decode: %w", err)
+	r := countingReader{r: resp.Body}
+	if err = json.NewDecoder(&r).Decode(&result); err != nil {
+		stats.Err = fmt.Errorf("decode: %w", err)
 	}
+	stats.Bytes = r.n
+	done(stats)
+	return result, stats.Err
+}
 
-	return result, nil
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// referenceCache holds the reference data (genres, languages, etc.) retrieved by the client. Reference data rarely
+// changes, so it is kept for the lifetime of the client.
+type referenceCache struct {
+	lock   sync.RWMutex
+	values map[string]any
+}
+
+func cachedCall[T any](ctx context.Context, c Client, ep endpoint, values url.Values) (T, error) {
+	if c.cache == nil {
+		return call[T](ctx, c, ep, values)
+	}
+	key := ep.path + "|" + c.IncludeAdult + "|" + c.Language + "|" + values.Encode()
+	c.cache.lock.RLock()
+	value, ok := c.cache.values[key]
+	c.cache.lock.RUnlock()
+	if ok {
+		_, done := c.instrument(ctx, RequestInfo{Method: http.MethodGet, Endpoint: ep.template, URL: c.BaseURL + ep.path})
+		done(RequestResult{StatusCode: http.StatusOK, CacheHit: true})
+		return value.(T), nil
+	}
+	result, err := call[T](ctx, c, ep, values)
+	if err == nil {
+		c.cache.lock.Lock()
+		c.cache.values[key] = result
+		c.cache.lock.Unlock()
+	}
+	return result, err
 }
@@ -0,0 +1,117 @@
+// Package tmdbotel reports the API calls made by a tmdb.Client as OpenTelemetry traces and metrics.
+package tmdbotel
+
+import (
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strconv"
+)
+
+const scope = "github.com/clambin/tmdb/pkg/tmdb"
+
+var _ tmdb.Instrumentation = &Instrumentation{}
+
+// Instrumentation implements tmdb.Instrumentation. Each API call results in a client span and is recorded in
+// the http.client.request.duration and http.client.response.body.size histograms. Results served from the client's
+// cache are marked with tmdb.cache_hit on their span and only counted in tmdb.client.cache_hits.
+type Instrumentation struct {
+	tracer    trace.Tracer
+	duration  metric.Float64Histogram
+	size      metric.Int64Histogram
+	cacheHits metric.Int64Counter
+}
+
+// New returns an Instrumentation using the provided providers. If a provider is nil, the global provider is used.
+func New(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) (*Instrumentation, error) {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+	meter := meterProvider.Meter(scope)
+	duration, err := meter.Float64Histogram("http.client.request.duration",
+		metric.WithDescription("Duration of TMDB API calls"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	size, err := meter.Int64Histogram("http.client.response.body.size",
+		metric.WithDescription("Size of TMDB API responses"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	cacheHits, err := meter.Int64Counter("tmdb.client.cache_hits",
+		metric.WithDescription("Number of TMDB API calls served from the client's cache"),
+		metric.WithUnit("{call}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Instrumentation{
+		tracer:    tracerProvider.Tracer(scope),
+		duration:  duration,
+		size:      size,
+		cacheHits: cacheHits,
+	}, nil
+}
+
+func (i *Instrumentation) RequestStarted(ctx context.Context, request tmdb.RequestInfo) context.Context {
+	ctx, _ = i.tracer.Start(ctx, request.Method+" "+request.Endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", request.Method),
+			attribute.String("url.template", request.Endpoint),
+			attribute.String("url.full", request.URL),
+		),
+	)
+	return ctx
+}
+
+func (i *Instrumentation) RequestFinished(ctx context.Context, request tmdb.RequestInfo, result tmdb.RequestResult) {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", request.Method),
+		attribute.String("url.template", request.Endpoint),
+	}
+	if result.StatusCode != 0 {
+		attrs = append(attrs, attribute.Int("http.response.status_code", result.StatusCode))
+	}
+	if result.Err != nil {
+		attrs = append(attrs, attribute.String("error.type", errorType(result)))
+	}
+
+	span := trace.SpanFromContext(ctx)
+	if result.CacheHit {
+		span.SetAttributes(attribute.Bool("tmdb.cache_hit", true))
+		span.End()
+		i.cacheHits.Add(ctx, 1, metric.WithAttributes(attrs...))
+		return
+	}
+	span.SetAttributes(attrs...)
+	if result.Err != nil {
+		span.SetStatus(codes.Error, result.Err.Error())
+	}
+	span.End()
+
+	i.duration.Record(ctx, result.Duration.Seconds(), metric.WithAttributes(attrs...))
+	i.size.Record(ctx, result.Bytes, metric.WithAttributes(attrs...))
+}
+
+// errorType returns the error.type of a failed call, as defined by the HTTP semantic conventions: the status code for
+// error responses, "_OTHER" for calls that failed without one (e.g. the connection failed, or the response couldn't be
+// decoded).
+func errorType(result tmdb.RequestResult) string {
+	if result.StatusCode >= http.StatusBadRequest {
+		return strconv.Itoa(result.StatusCode)
+	}
+	return "_OTHER"
+}
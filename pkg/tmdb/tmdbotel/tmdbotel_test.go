@@ -0,0 +1,89 @@
+package tmdbotel_test
+
+import (
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbotel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInstrumentation(t *testing.T) {
+	m := http.NewServeMux()
+	m.HandleFunc("GET /3/person/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "31" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":31,"name":"Tom Hanks"}`))
+	})
+	m.HandleFunc("GET /3/genre/movie/list", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"genres":[{"id":80,"name":"Crime"}]}`))
+	})
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	instrumentation, err := tmdbotel.New(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	)
+	require.NoError(t, err)
+
+	c := tmdb.New("", nil)
+	c.BaseURL = s.URL
+	c.Instrumentation = instrumentation
+
+	ctx := context.Background()
+	_, err = c.GetPerson(ctx, 31)
+	require.NoError(t, err)
+	_, err = c.GetPerson(ctx, 1)
+	require.Error(t, err)
+	// the second call is served from the client's cache and doesn't call TMDB
+	for range 2 {
+		_, err = c.GenreName(ctx, 80, "movie")
+		require.NoError(t, err)
+	}
+
+	ended := spans.Ended()
+	require.Len(t, ended, 4)
+	assert.Equal(t, "GET /3/person/{id}", ended[0].Name())
+	assert.Equal(t, codes.Unset, ended[0].Status().Code)
+	assert.Contains(t, ended[0].Attributes(), attribute.Int("http.response.status_code", http.StatusOK))
+	assert.Equal(t, codes.Error, ended[1].Status().Code)
+	assert.Contains(t, ended[1].Attributes(), attribute.Int("http.response.status_code", http.StatusNotFound))
+	assert.Contains(t, ended[1].Attributes(), attribute.String("error.type", "404"))
+	assert.NotContains(t, ended[2].Attributes(), attribute.Bool("tmdb.cache_hit", true))
+	assert.Contains(t, ended[3].Attributes(), attribute.Bool("tmdb.cache_hit", true))
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	names := make(map[string]int)
+	for _, metric := range rm.ScopeMetrics[0].Metrics {
+		switch data := metric.Data.(type) {
+		case metricdata.Histogram[float64]:
+			names[metric.Name] = len(data.DataPoints)
+			for _, dp := range data.DataPoints {
+				assert.Equal(t, uint64(1), dp.Count)
+			}
+		case metricdata.Histogram[int64]:
+			names[metric.Name] = len(data.DataPoints)
+		case metricdata.Sum[int64]:
+			names[metric.Name] = len(data.DataPoints)
+			assert.Equal(t, int64(1), data.DataPoints[0].Value)
+		}
+	}
+	// cache hits aren't TMDB calls: they're only counted in tmdb.client.cache_hits
+	assert.Equal(t, map[string]int{"http.client.request.duration": 3, "http.client.response.body.size": 3, "tmdb.client.cache_hits": 1}, names)
+}
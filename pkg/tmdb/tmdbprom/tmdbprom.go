@@ -0,0 +1,101 @@
+// Package tmdbprom reports the API calls made by a tmdb.Client as Prometheus metrics.
+package tmdbprom
+
+import (
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+)
+
+var _ tmdb.Instrumentation = &Metrics{}
+var _ prometheus.Collector = &Metrics{}
+
+// Metrics implements tmdb.Instrumentation. The caller must register Metrics with a Prometheus registry.
+//
+// Results served from the client's cache are only counted in api_cache_hits_total.
+type Metrics struct {
+	requests  *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	bytes     *prometheus.CounterVec
+	cacheHits *prometheus.CounterVec
+	inflight  prometheus.Gauge
+}
+
+// New returns Metrics for the provided namespace and subsystem.
+func New(namespace, subsystem string, constLabels prometheus.Labels) *Metrics {
+	return &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "api_requests_total",
+			Help:        "Number of TMDB API calls",
+			ConstLabels: constLabels,
+		}, []string{"method", "endpoint", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "api_request_duration_seconds",
+			Help:        "Duration of TMDB API calls",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"method", "endpoint"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "api_response_bytes_total",
+			Help:        "Size of TMDB API responses",
+			ConstLabels: constLabels,
+		}, []string{"method", "endpoint"}),
+		cacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "api_cache_hits_total",
+			Help:        "Number of TMDB API calls served from the client's cache",
+			ConstLabels: constLabels,
+		}, []string{"method", "endpoint"}),
+		inflight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "api_requests_inflight",
+			Help:        "Number of TMDB API calls in progress",
+			ConstLabels: constLabels,
+		}),
+	}
+}
+
+func (m *Metrics) RequestStarted(ctx context.Context, _ tmdb.RequestInfo) context.Context {
+	m.inflight.Inc()
+	return ctx
+}
+
+func (m *Metrics) RequestFinished(_ context.Context, request tmdb.RequestInfo, result tmdb.RequestResult) {
+	m.inflight.Dec()
+	if result.CacheHit {
+		m.cacheHits.WithLabelValues(request.Method, request.Endpoint).Inc()
+		return
+	}
+	code := "error"
+	if result.StatusCode != 0 {
+		code = strconv.Itoa(result.StatusCode)
+	}
+	m.requests.WithLabelValues(request.Method, request.Endpoint, code).Inc()
+	m.duration.WithLabelValues(request.Method, request.Endpoint).Observe(result.Duration.Seconds())
+	m.bytes.WithLabelValues(request.Method, request.Endpoint).Add(float64(result.Bytes))
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.duration.Describe(ch)
+	m.bytes.Describe(ch)
+	m.cacheHits.Describe(ch)
+	m.inflight.Describe(ch)
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.duration.Collect(ch)
+	m.bytes.Collect(ch)
+	m.cacheHits.Collect(ch)
+	m.inflight.Collect(ch)
+}
@@ -0,0 +1,69 @@
+package tmdbprom_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbprom"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := http.NewServeMux()
+	m.HandleFunc("GET /3/movie/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "680" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":680,"title":"Pulp Fiction"}`))
+	})
+	m.HandleFunc("GET /3/genre/movie/list", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"genres":[{"id":80,"name":"Crime"}]}`))
+	})
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	metrics := tmdbprom.New("tmdb", "", nil)
+	c := tmdb.New("", nil)
+	c.BaseURL = s.URL
+	c.Instrumentation = metrics
+
+	ctx := context.Background()
+	_, err := c.GetMovie(ctx, 680)
+	require.NoError(t, err)
+	_, err = c.GetMovie(ctx, 1)
+	require.Error(t, err)
+	// the second call is served from the client's cache and doesn't call TMDB
+	for range 2 {
+		_, err = c.GenreName(ctx, 80, "movie")
+		require.NoError(t, err)
+	}
+
+	assert.NoError(t, testutil.CollectAndCompare(metrics, bytes.NewBufferString(`
+# HELP tmdb_api_cache_hits_total Number of TMDB API calls served from the client's cache
+# TYPE tmdb_api_cache_hits_total counter
+tmdb_api_cache_hits_total{endpoint="/3/genre/movie/list",method="GET"} 1
+
+# HELP tmdb_api_requests_inflight Number of TMDB API calls in progress
+# TYPE tmdb_api_requests_inflight gauge
+tmdb_api_requests_inflight 0
+
+# HELP tmdb_api_requests_total Number of TMDB API calls
+# TYPE tmdb_api_requests_total counter
+tmdb_api_requests_total{code="200",endpoint="/3/genre/movie/list",method="GET"} 1
+tmdb_api_requests_total{code="200",endpoint="/3/movie/{id}",method="GET"} 1
+tmdb_api_requests_total{code="404",endpoint="/3/movie/{id}",method="GET"} 1
+
+# HELP tmdb_api_response_bytes_total Size of TMDB API responses
+# TYPE tmdb_api_response_bytes_total counter
+tmdb_api_response_bytes_total{endpoint="/3/genre/movie/list",method="GET"} 37
+tmdb_api_response_bytes_total{endpoint="/3/movie/{id}",method="GET"} 33
+`), "tmdb_api_cache_hits_total", "tmdb_api_requests_inflight", "tmdb_api_requests_total", "tmdb_api_response_bytes_total"))
+	// cache hits aren't TMDB calls: they're not in the duration histogram
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics, "tmdb_api_request_duration_seconds"))
+}
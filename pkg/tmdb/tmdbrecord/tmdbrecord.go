@@ -0,0 +1,195 @@
+// Package tmdbrecord records the calls made by a tmdb.Client, and replays them without calling TMDB.
+package tmdbrecord
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Recorder is an http.RoundTripper that writes every exchange with TMDB to a file in Directory. A Replayer can then
+// serve the recorded responses, so tests can use real TMDB payloads without network access:
+//
+//	c := tmdb.New(token, &http.Client{Transport: &tmdbrecord.Recorder{Directory: "testdata/recorded"}})
+//
+// Credentials are never recorded.
+type Recorder struct {
+	Directory string
+	// Next makes the actual call. Defaults to http.DefaultTransport.
+	Next http.RoundTripper
+}
+
+// Replayer is an http.RoundTripper that serves the responses recorded by a Recorder in Directory. It never calls
+// TMDB: requests that weren't recorded fail with ErrNotRecorded.
+//
+//	c := tmdb.New("", &http.Client{Transport: tmdbrecord.Replayer{Directory: "testdata/recorded"}})
+type Replayer struct {
+	Directory string
+}
+
+// ErrNotRecorded is returned by Replayer for requests that weren't recorded.
+var ErrNotRecorded = errors.New("request not recorded")
+
+// exchange is a recorded request and its response.
+type exchange struct {
+	Request struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int         `json:"status_code"`
+		Header     http.Header `json:"header,omitempty"`
+		// Body holds JSON responses as-is, for readability. Other responses are in BodyBytes.
+		Body      json.RawMessage `json:"body,omitempty"`
+		BodyBytes []byte          `json:"body_bytes,omitempty"`
+	} `json:"response"`
+}
+
+// recordedHeaders are the response headers that are recorded.
+var recordedHeaders = []string{"Content-Type", "Cache-Control", "ETag", "Last-Modified", "Retry-After"}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := cmp.Or[http.RoundTripper](r.Next, http.DefaultTransport).RoundTrip(req)
+	// a 304 depends on the request's validators: replaying it for other requests would be wrong
+	if err != nil || resp.StatusCode == http.StatusNotModified {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var e exchange
+	e.Request.Method = req.Method
+	e.Request.URL = requestURL(req)
+	e.Response.StatusCode = resp.StatusCode
+	e.Response.Header = make(http.Header)
+	for _, name := range recordedHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			e.Response.Header[name] = values
+		}
+	}
+	// responses are recorded uncompressed, so they can be read and edited
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err == nil {
+			body, err = io.ReadAll(zr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %w", e.Request.URL, err)
+		}
+	}
+	if json.Valid(body) {
+		e.Response.Body = body
+	} else {
+		e.Response.BodyBytes = body
+	}
+	if err = r.write(req, e); err != nil {
+		return nil, fmt.Errorf("record %s: %w", e.Request.URL, err)
+	}
+	return resp, nil
+}
+
+func (r *Recorder) write(req *http.Request, e exchange) error {
+	if err := os.MkdirAll(r.Directory, 0o750); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write the file atomically, so concurrent recordings of the same request don't corrupt it
+	f, err := os.CreateTemp(r.Directory, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(content, '\n'))
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(r.Directory, exchangeFile(req)))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (r Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	file := filepath.Join(r.Directory, exchangeFile(req))
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s %s (%s)", ErrNotRecorded, req.Method, requestURL(req), file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e exchange
+	if err = json.Unmarshal(content, &e); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	body := []byte(e.Response.Body)
+	if body == nil {
+		body = e.Response.BodyBytes
+	}
+	header := e.Response.Header
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        strconv.Itoa(e.Response.StatusCode) + " " + http.StatusText(e.Response.StatusCode),
+		StatusCode:    e.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// requestURL returns the path and query of the request, without the api_key parameter. The query parameters are
+// sorted, so equivalent requests get the same URL.
+func requestURL(req *http.Request) string {
+	query := req.URL.Query()
+	query.Del("api_key")
+	if len(query) == 0 {
+		return req.URL.EscapedPath()
+	}
+	return req.URL.EscapedPath() + "?" + query.Encode()
+}
+
+// exchangeFile returns the name of the file that holds the exchange of the request, e.g.
+// "get-3-movie-550-credits-1c6e2a4f.json". The suffix is derived from the query parameters.
+func exchangeFile(req *http.Request) string {
+	var name strings.Builder
+	name.WriteString(strings.ToLower(req.Method))
+	for _, r := range req.URL.Path {
+		switch {
+		case r == '/':
+			name.WriteByte('-')
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.':
+			name.WriteRune(r)
+		default:
+			name.WriteByte('_')
+		}
+	}
+	hash := sha256.Sum256([]byte(requestURL(req)))
+	return name.String() + "-" + hex.EncodeToString(hash[:4]) + ".json"
+}
@@ -0,0 +1,97 @@
+package tmdbrecord_test
+
+import (
+	"compress/gzip"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbrecord"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	s := tmdbtest.NewServer()
+	s.AuthKey = "secret"
+	s.AddPerson(tmdb.Person{Id: 31, Name: "Tom Hanks", Popularity: 10})
+	s.AddMovieCredits(tmdb.MovieCredits{Id: 13, Cast: []tmdb.MovieCastCredits{{Id: 31, Name: "Tom Hanks"}}})
+
+	dir := t.TempDir()
+	c := tmdb.New("secret", &http.Client{Transport: &tmdbrecord.Recorder{Directory: dir}})
+	c.BaseURL = s.URL
+	ctx := t.Context()
+
+	person, err := c.GetPerson(ctx, 31)
+	require.NoError(t, err)
+	persons, _, err := c.SearchPersonPage(ctx, "tom", 1)
+	require.NoError(t, err)
+	credits, err := c.GetMovieCredits(ctx, 13)
+	require.NoError(t, err)
+	_, err = c.GetPerson(ctx, 32)
+	require.Error(t, err)
+	s.Close()
+
+	// credentials are not recorded
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+	assert.Len(t, files, 4)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "secret")
+	}
+
+	// replaying doesn't need the server
+	c = tmdb.New("", &http.Client{Transport: tmdbrecord.Replayer{Directory: dir}})
+	replayedPerson, err := c.GetPerson(ctx, 31)
+	require.NoError(t, err)
+	assert.Equal(t, person, replayedPerson)
+	replayedPersons, _, err := c.SearchPersonPage(ctx, "tom", 1)
+	require.NoError(t, err)
+	assert.Equal(t, persons, replayedPersons)
+	replayedCredits, err := c.GetMovieCredits(ctx, 13)
+	require.NoError(t, err)
+	assert.Equal(t, credits, replayedCredits)
+	_, err = c.GetPerson(ctx, 32)
+	assert.ErrorContains(t, err, "404")
+
+	_, err = c.GetMovie(ctx, 13)
+	assert.ErrorIs(t, err, tmdbrecord.ErrNotRecorded)
+	c.Language = "nl-BE"
+	_, err = c.GetPerson(ctx, 31)
+	assert.ErrorIs(t, err, tmdbrecord.ErrNotRecorded)
+}
+
+func TestRecorder_Gzip(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		_, _ = zw.Write([]byte(`{"id":550}`))
+		_ = zw.Close()
+	}))
+	t.Cleanup(s.Close)
+
+	dir := t.TempDir()
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"/3/movie/550?api_key=secret", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := (&tmdbrecord.Recorder{Directory: dir}).RoundTrip(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	// the caller gets the response as sent by the server
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.NotEqual(t, `{"id":550}`, string(body))
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost/3/movie/550", nil)
+	resp, err = tmdbrecord.Replayer{Directory: dir}.RoundTrip(req)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.JSONEq(t, `{"id":550}`, string(body))
+}
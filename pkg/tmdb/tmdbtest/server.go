@@ -0,0 +1,320 @@
+// Package tmdbtest provides an in-process fake TMDB server, for testing code that uses the tmdb package.
+package tmdbtest
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPageSize is the number of results per page returned by paginated endpoints, as used by TMDB.
+const DefaultPageSize = 20
+
+// Server is a fake TMDB server. It serves the search, movie, person and credits endpoints from data added with
+// AddPerson, AddMovie, etc. or loaded with LoadFixtures.
+//
+// Create a Server with NewServer and point a tmdb.Client to it with Client:
+//
+//	s := tmdbtest.NewServer()
+//	defer s.Close()
+//	s.AddPerson(tmdb.Person{Id: 31, Name: "Tom Hanks"})
+//	c := s.Client()
+type Server struct {
+	*httptest.Server
+	// AuthKey, if set, is the bearer token that clients must present.
+	AuthKey string
+	// PageSize is the number of results per page for paginated endpoints.
+	PageSize int
+
+	lock          sync.RWMutex
+	persons       map[int]tmdb.Person
+	personCredits map[int]tmdb.PersonCredits
+	movies        map[int]tmdb.Movie
+	movieCredits  map[int]tmdb.MovieCredits
+	faults        []*fault
+	requests      []string
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := Server{
+		PageSize:      DefaultPageSize,
+		persons:       make(map[int]tmdb.Person),
+		personCredits: make(map[int]tmdb.PersonCredits),
+		movies:        make(map[int]tmdb.Movie),
+		movieCredits:  make(map[int]tmdb.MovieCredits),
+	}
+	m := http.NewServeMux()
+	m.HandleFunc("GET /3/search/person", s.searchPerson)
+	m.HandleFunc("GET /3/person/{id}", get(&s, s.persons))
+	m.HandleFunc("GET /3/person/{id}/combined_credits", get(&s, s.personCredits))
+	m.HandleFunc("GET /3/movie/{id}", get(&s, s.movies))
+	m.HandleFunc("GET /3/movie/{id}/credits", get(&s, s.movieCredits))
+	m.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusNotFound, 34, "The resource you requested could not be found.")
+	})
+	s.Server = httptest.NewServer(s.middleware(m))
+	return &s
+}
+
+// Client returns a tmdb.Client that calls the Server. The client has its own http.Client: tmdb.New wraps the client's
+// transport, so passing nil (i.e. http.DefaultClient) would change it for the whole test binary.
+func (s *Server) Client() *tmdb.Client {
+	c := tmdb.New(s.AuthKey, &http.Client{Transport: s.Server.Client().Transport})
+	c.BaseURL = s.URL
+	return c
+}
+
+func (s *Server) AddPerson(person tmdb.Person) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.persons[person.Id] = person
+}
+
+func (s *Server) AddPersonCredits(credits tmdb.PersonCredits) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.personCredits[credits.Id] = credits
+}
+
+func (s *Server) AddMovie(movie tmdb.Movie) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.movies[movie.Id] = movie
+}
+
+func (s *Server) AddMovieCredits(credits tmdb.MovieCredits) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.movieCredits[credits.Id] = credits
+}
+
+var fixtureName = regexp.MustCompile(`^(?:(get-person|get-person-credits|get-movie|get-movie-credits)-\d+|(search)-.+)\.json$`)
+
+// LoadFixtures loads all JSON fixtures in dir. Fixtures contain a TMDB response and are named after the request,
+// as in the tmdb package's testdata:
+//
+//	get-person-<id>.json            /3/person/{id}
+//	get-person-credits-<id>.json    /3/person/{id}/combined_credits
+//	get-movie-<id>.json             /3/movie/{id}
+//	get-movie-credits-<id>.json     /3/movie/{id}/credits
+//	search-<query>-<page>.json      /3/search/person (all persons in the results are added)
+//
+// Other files are ignored.
+func (s *Server) LoadFixtures(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		match := fixtureName.FindStringSubmatch(filepath.Base(file))
+		if match == nil {
+			continue
+		}
+		if err = s.loadFixture(file, cmp.Or(match[1], match[2])); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) loadFixture(file string, kind string) error {
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case "get-person":
+		var p tmdb.Person
+		if err = json.Unmarshal(body, &p); err == nil {
+			s.AddPerson(p)
+		}
+	case "get-person-credits":
+		var credits tmdb.PersonCredits
+		if err = json.Unmarshal(body, &credits); err == nil {
+			s.AddPersonCredits(credits)
+		}
+	case "get-movie":
+		var m tmdb.Movie
+		if err = json.Unmarshal(body, &m); err == nil {
+			s.AddMovie(m)
+		}
+	case "get-movie-credits":
+		var credits tmdb.MovieCredits
+		if err = json.Unmarshal(body, &credits); err == nil {
+			s.AddMovieCredits(credits)
+		}
+	case "search":
+		var page tmdb.PersonsPage
+		if err = json.Unmarshal(body, &page); err == nil {
+			for _, p := range page.Results {
+				s.AddPerson(p)
+			}
+		}
+	}
+	return err
+}
+
+// Fault describes an error injected by the Server.
+type Fault struct {
+	// StatusCode is the HTTP status code returned for the request. If zero, the request is handled normally,
+	// i.e. the fault only adds Latency.
+	StatusCode int
+	// Latency delays the response.
+	Latency time.Duration
+	// RetryAfter sets the Retry-After header of the response.
+	RetryAfter time.Duration
+	// Count is the number of requests affected by the fault. If zero, all matching requests are affected.
+	Count int
+}
+
+type fault struct {
+	Fault
+	pattern string
+	hits    int
+}
+
+// InjectFault applies a Fault to all requests whose path matches pattern, as per path.Match (e.g. "/3/movie/*").
+// If multiple faults match a request, the first one that was injected is applied.
+func (s *Server) InjectFault(pattern string, f Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = append(s.faults, &fault{Fault: f, pattern: pattern})
+}
+
+// ClearFaults removes all injected faults.
+func (s *Server) ClearFaults() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = nil
+}
+
+// RequestCount returns the number of requests received whose path matches pattern, as per path.Match.
+func (s *Server) RequestCount(pattern string) int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var count int
+	for _, p := range s.requests {
+		if ok, _ := path.Match(pattern, p); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := s.recordRequest(r.URL.Path)
+		if f != nil {
+			if f.Latency > 0 {
+				select {
+				case <-time.After(f.Latency):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			if f.StatusCode != 0 {
+				if f.RetryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(f.RetryAfter.Seconds())))
+				}
+				writeError(w, f.StatusCode, 0, http.StatusText(f.StatusCode))
+				return
+			}
+		}
+		if s.AuthKey != "" && r.Header.Get("Authorization") != "Bearer "+s.AuthKey {
+			writeError(w, http.StatusUnauthorized, 7, "Invalid API key: You must be granted a valid key.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) recordRequest(p string) *fault {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = append(s.requests, p)
+	for _, f := range s.faults {
+		if ok, _ := path.Match(f.pattern, p); ok && (f.Count == 0 || f.hits < f.Count) {
+			f.hits++
+			return f
+		}
+	}
+	return nil
+}
+
+func (s *Server) searchPerson(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(r.FormValue("query"))
+	page, err := strconv.Atoi(cmp.Or(r.FormValue("page"), "1"))
+	if err != nil || page < 1 {
+		writeError(w, http.StatusBadRequest, 22, "Invalid page: Pages start at 1 and max at 500.")
+		return
+	}
+
+	s.lock.RLock()
+	var persons []tmdb.Person
+	for _, p := range s.persons {
+		if query != "" && strings.Contains(strings.ToLower(p.Name), query) {
+			persons = append(persons, p)
+		}
+	}
+	s.lock.RUnlock()
+
+	slices.SortFunc(persons, func(a, b tmdb.Person) int {
+		return cmp.Or(-cmp.Compare(a.Popularity, b.Popularity), cmp.Compare(a.Id, b.Id))
+	})
+	pageSize := cmp.Or(s.PageSize, DefaultPageSize)
+	result := tmdb.PersonsPage{
+		Page:         page,
+		Results:      []tmdb.Person{},
+		TotalPages:   max(1, (len(persons)+pageSize-1)/pageSize),
+		TotalResults: len(persons),
+	}
+	if start := (page - 1) * pageSize; start < len(persons) {
+		result.Results = persons[start:min(start+pageSize, len(persons))]
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func get[T any](s *Server, values map[int]T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, 34, "The resource you requested could not be found.")
+			return
+		}
+		s.lock.RLock()
+		value, ok := values[id]
+		s.lock.RUnlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, 34, "The resource you requested could not be found.")
+			return
+		}
+		writeJSON(w, http.StatusOK, value)
+	}
+}
+
+func writeError(w http.ResponseWriter, statusCode int, tmdbCode int, message string) {
+	writeJSON(w, statusCode, struct {
+		Success       bool   `json:"success"`
+		StatusCode    int    `json:"status_code"`
+		StatusMessage string `json:"status_message"`
+	}{StatusCode: tmdbCode, StatusMessage: message})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value any) {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,132 @@
+package tmdbtest_test
+
+import (
+	"context"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/clambin/tmdb/pkg/tmdb/tmdbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	s := tmdbtest.NewServer()
+	t.Cleanup(s.Close)
+	s.AuthKey = "key"
+	s.PageSize = 2
+	for i := range 5 {
+		s.AddPerson(tmdb.Person{Id: i + 1, Name: "actor " + strconv.Itoa(i+1), Popularity: float64(i)})
+	}
+	s.AddPersonCredits(tmdb.PersonCredits{Id: 1, Cast: []tmdb.CastCredit{{Id: 10, MediaType: "movie", Title: "movie"}}})
+	s.AddMovie(tmdb.Movie{Id: 10, Title: "movie"})
+	s.AddMovieCredits(tmdb.MovieCredits{Id: 10, Cast: []tmdb.MovieCastCredits{{Id: 1, Name: "actor 1"}}})
+
+	c := s.Client()
+	assert.Nil(t, http.DefaultClient.Transport, "Client must not change http.DefaultClient")
+	ctx := context.Background()
+
+	persons, totalPages, err := c.SearchPersonPage(ctx, "ACTOR", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, totalPages)
+	require.Len(t, persons, 2)
+	assert.Equal(t, 5, persons[0].Id)
+
+	persons, err = c.SearchPersonAllPages(ctx, "actor")
+	require.NoError(t, err)
+	assert.Len(t, persons, 5)
+
+	persons, err = c.SearchPersonAllPages(ctx, "nobody")
+	require.NoError(t, err)
+	assert.Empty(t, persons)
+
+	person, err := c.GetPerson(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "actor 1", person.Name)
+	_, err = c.GetPerson(ctx, 100)
+	assert.Error(t, err)
+
+	personCredits, err := c.GetPersonCredits(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, personCredits.Cast, 1)
+
+	movie, err := c.GetMovie(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "movie", movie.Title)
+
+	movieCredits, err := c.GetMovieCredits(ctx, 10)
+	require.NoError(t, err)
+	assert.Len(t, movieCredits.Cast, 1)
+
+	assert.Equal(t, 5, s.RequestCount("/3/search/person"))
+	assert.Equal(t, 2, s.RequestCount("/3/person/*"))
+
+	c2 := tmdb.New("invalid-key", nil)
+	c2.BaseURL = s.URL
+	_, err = c2.GetPerson(ctx, 1)
+	assert.Error(t, err)
+}
+
+func TestServer_LoadFixtures(t *testing.T) {
+	s := tmdbtest.NewServer()
+	t.Cleanup(s.Close)
+	require.NoError(t, s.LoadFixtures("../testdata"))
+	c := s.Client()
+	ctx := context.Background()
+
+	persons, err := c.SearchPersonAllPages(ctx, "tom hanks")
+	require.NoError(t, err)
+	require.NotEmpty(t, persons)
+	assert.Equal(t, 31, persons[0].Id)
+
+	person, err := c.GetPerson(ctx, 31)
+	require.NoError(t, err)
+	assert.Equal(t, "Tom Hanks", person.Name)
+
+	credits, err := c.GetPersonCredits(ctx, 31)
+	require.NoError(t, err)
+	assert.Len(t, credits.Cast, 236)
+
+	movieCredits, err := c.GetMovieCredits(ctx, 680)
+	require.NoError(t, err)
+	assert.NotEmpty(t, movieCredits.Cast)
+
+	assert.Error(t, s.LoadFixtures("["))
+}
+
+func TestServer_InjectFault(t *testing.T) {
+	s := tmdbtest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddMovie(tmdb.Movie{Id: 1})
+	c := s.Client()
+	ctx := context.Background()
+
+	s.InjectFault("/3/movie/*", tmdbtest.Fault{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second, Count: 1})
+	s.InjectFault("/3/movie/*", tmdbtest.Fault{StatusCode: http.StatusServiceUnavailable, Count: 1})
+	s.InjectFault("/3/movie/*", tmdbtest.Fault{Latency: 100 * time.Millisecond, Count: 1})
+
+	resp, err := http.Get(s.URL + "/3/movie/1")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	_, err = c.GetMovie(ctx, 1)
+	assert.Error(t, err)
+
+	start := time.Now()
+	_, err = c.GetMovie(ctx, 1)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	s.InjectFault("/3/movie/1", tmdbtest.Fault{StatusCode: http.StatusInternalServerError})
+	for range 2 {
+		_, err = c.GetMovie(ctx, 1)
+		assert.Error(t, err)
+	}
+	s.ClearFaults()
+	_, err = c.GetMovie(ctx, 1)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,133 @@
+package tmdb
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// V4Client calls TMDB's v4 API. It shares the http client (and therefore the application's bearer token) of the
+// Client that created it. Endpoints that act on behalf of a user require an access token, set via WithAccessToken.
+type V4Client struct {
+	client      Client
+	accessToken string
+}
+
+// V4 returns a client for TMDB's v4 API.
+func (c Client) V4() V4Client {
+	return V4Client{client: c}
+}
+
+// WithAccessToken returns a copy of the client that authenticates as the user who owns the access token.
+func (c V4Client) WithAccessToken(accessToken string) V4Client {
+	c.accessToken = accessToken
+	return c
+}
+
+func (c V4Client) form(page int) url.Values {
+	form := make(url.Values)
+	form.Add("language", c.client.Language)
+	if page > 0 {
+		form.Add("page", strconv.Itoa(page))
+	}
+	return form
+}
+
+type v4Status struct {
+	StatusMessage string `json:"status_message"`
+	StatusCode    int    `json:"status_code"`
+	Success       bool   `json:"success"`
+}
+
+type requestToken struct {
+	RequestToken string `json:"request_token"`
+	v4Status
+}
+
+// CreateRequestToken starts the user authentication flow. The user approves the token at ApprovalURL, after which
+// TMDB redirects to redirectTo (if set) and the token can be exchanged for an access token with CreateAccessToken.
+func (c V4Client) CreateRequestToken(ctx context.Context, redirectTo string) (string, error) {
+	body := struct {
+		RedirectTo string `json:"redirect_to,omitempty"`
+	}{RedirectTo: redirectTo}
+	resp, err := do[requestToken](ctx, c.client, http.MethodPost, route("/4/auth/request_token"), nil, "", body)
+	return resp.RequestToken, err
+}
+
+// ApprovalURL returns the URL where the user approves the request token.
+func (c V4Client) ApprovalURL(requestToken string) string {
+	return "https://www.themoviedb.org/auth/access?request_token=" + url.QueryEscape(requestToken)
+}
+
+type AccessToken struct {
+	AccountId   string `json:"account_id"`
+	AccessToken string `json:"access_token"`
+	v4Status
+}
+
+// CreateAccessToken exchanges an approved request token for a user access token.
+func (c V4Client) CreateAccessToken(ctx context.Context, requestToken string) (AccessToken, error) {
+	body := struct {
+		RequestToken string `json:"request_token"`
+	}{RequestToken: requestToken}
+	return do[AccessToken](ctx, c.client, http.MethodPost, route("/4/auth/access_token"), nil, "", body)
+}
+
+// DeleteAccessToken logs out the user by invalidating the client's access token.
+func (c V4Client) DeleteAccessToken(ctx context.Context) error {
+	body := struct {
+		AccessToken string `json:"access_token"`
+	}{AccessToken: c.accessToken}
+	_, err := do[v4Status](ctx, c.client, http.MethodDelete, route("/4/auth/access_token"), nil, "", body)
+	return err
+}
+
+// Page is a single page of results of a paginated v4 endpoint.
+type Page[T any] struct {
+	Page         int `json:"page"`
+	Results      []T `json:"results"`
+	TotalPages   int `json:"total_pages"`
+	TotalResults int `json:"total_results"`
+}
+
+// A Cursor walks through all pages of a paginated v4 endpoint.
+type Cursor[T any] struct {
+	fetch      func(ctx context.Context, page int) (Page[T], error)
+	page       int
+	totalPages int
+}
+
+func newCursor[T any](fetch func(context.Context, int) (Page[T], error)) *Cursor[T] {
+	return &Cursor[T]{fetch: fetch, totalPages: 1}
+}
+
+// Next returns the results of the next page. Once all pages have been read, Next returns io.EOF.
+func (c *Cursor[T]) Next(ctx context.Context) ([]T, error) {
+	if c.page >= c.totalPages {
+		return nil, io.EOF
+	}
+	p, err := c.fetch(ctx, c.page+1)
+	if err != nil {
+		return nil, err
+	}
+	c.page++
+	c.totalPages = p.TotalPages
+	return p.Results, nil
+}
+
+// All returns the results of all remaining pages.
+func (c *Cursor[T]) All(ctx context.Context) ([]T, error) {
+	var all []T
+	for {
+		results, err := c.Next(ctx)
+		if err == io.EOF {
+			return all, nil
+		}
+		if err != nil {
+			return all, err
+		}
+		all = append(all, results...)
+	}
+}
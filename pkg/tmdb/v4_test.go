@@ -0,0 +1,109 @@
+package tmdb_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/clambin/tmdb/pkg/tmdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestV4Client_Auth(t *testing.T) {
+	m := http.NewServeMux()
+	m.HandleFunc("POST /4/auth/request_token", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RedirectTo string `json:"redirect_to"`
+		}
+		if r.Header.Get("Authorization") != "Bearer app-key" || json.NewDecoder(r.Body).Decode(&body) != nil || body.RedirectTo != "http://localhost/callback" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"status_code":1,"request_token":"request-token"}`))
+	})
+	m.HandleFunc("POST /4/auth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RequestToken string `json:"request_token"`
+		}
+		if json.NewDecoder(r.Body).Decode(&body) != nil || body.RequestToken != "request-token" {
+			http.Error(w, "not approved", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"status_code":1,"account_id":"account","access_token":"access-token"}`))
+	})
+	m.HandleFunc("DELETE /4/auth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			AccessToken string `json:"access_token"`
+		}
+		if json.NewDecoder(r.Body).Decode(&body) != nil || body.AccessToken != "access-token" {
+			http.Error(w, "invalid token", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"status_code":13}`))
+	})
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	c := tmdb.New("app-key", nil)
+	c.BaseURL = s.URL
+	v4 := c.V4()
+
+	ctx := context.Background()
+	requestToken, err := v4.CreateRequestToken(ctx, "http://localhost/callback")
+	require.NoError(t, err)
+	assert.Equal(t, "request-token", requestToken)
+	assert.Equal(t, "https://www.themoviedb.org/auth/access?request_token=request-token", v4.ApprovalURL(requestToken))
+
+	_, err = v4.CreateAccessToken(ctx, "invalid-token")
+	assert.Error(t, err)
+
+	accessToken, err := v4.CreateAccessToken(ctx, requestToken)
+	require.NoError(t, err)
+	assert.Equal(t, "account", accessToken.AccountId)
+	assert.Equal(t, "access-token", accessToken.AccessToken)
+
+	assert.NoError(t, v4.WithAccessToken(accessToken.AccessToken).DeleteAccessToken(ctx))
+	assert.Error(t, v4.DeleteAccessToken(ctx))
+}
+
+func TestCursor(t *testing.T) {
+	const totalPages = 3
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			http.Error(w, "access token required", http.StatusUnauthorized)
+			return
+		}
+		page, _ := strconv.Atoi(r.FormValue("page"))
+		_ = json.NewEncoder(w).Encode(tmdb.Page[tmdb.ListEntry]{
+			Page:         page,
+			Results:      []tmdb.ListEntry{{Id: page, Title: "movie " + strconv.Itoa(page)}},
+			TotalPages:   totalPages,
+			TotalResults: totalPages,
+		})
+	}))
+	t.Cleanup(s.Close)
+
+	c := tmdb.New("app-key", nil)
+	c.BaseURL = s.URL
+	ctx := context.Background()
+
+	_, err := c.V4().AccountWatchlist("account", "movie").Next(ctx)
+	assert.Error(t, err)
+
+	cursor := c.V4().WithAccessToken("access-token").AccountWatchlist("account", "movie")
+	results, err := cursor.Next(ctx)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "movie 1", results[0].GetTitle())
+
+	results, err = cursor.All(ctx)
+	require.NoError(t, err)
+	assert.Len(t, results, totalPages-1)
+
+	_, err = cursor.Next(ctx)
+	assert.ErrorIs(t, err, io.EOF)
+}